@@ -33,10 +33,16 @@ type VarStatement struct {
 func (vs *VarStatement) statementNode() {}
 func (vs *VarStatement) NType() string  { return "VarStatement" }
 func (vs *VarStatement) Literal() string {
+	if vs.Value == nil {
+		return fmt.Sprintf("token: %s, name: %s, type: %s\n", vs.Token.Tok.String(), vs.Name.Literal(), vs.Type.Literal())
+	}
 	return fmt.Sprintf("token: %s, name: %s, value: %s, type: %s\n", vs.Token.Tok.String(), vs.Name.Literal(), vs.Value.Literal(), vs.Type.Literal())
 	// return fmt.Sprintf("token: %s, name: %s, type: %s\n", vs.Token.Tok.String(), vs.Name.Literal(), vs.Type.Literal())
 }
 func (vs *VarStatement) String() string {
+	if vs.Value == nil {
+		return fmt.Sprintf("(%s %s)", vs.Type.String(), vs.Name.String())
+	}
 	return fmt.Sprintf("(%s %s = %s)", vs.Type.String(), vs.Name.String(), vs.Value.String())
 }
 
@@ -55,6 +61,22 @@ func (vrs *VarReassignmentStatement) String() string {
 	return fmt.Sprintf("(%s = %s)", vrs.Name.String(), vrs.Value.String())
 }
 
+// AssignmentStatement stores a value somewhere other than a plain variable, like an array element or a struct field.
+type AssignmentStatement struct {
+	Token  lex.LexedTok
+	Target Expression
+	Value  Expression
+}
+
+func (as *AssignmentStatement) statementNode() {}
+func (as *AssignmentStatement) NType() string  { return "AssignmentStatement" }
+func (as *AssignmentStatement) Literal() string {
+	return fmt.Sprintf("token: %s, target: %s, value: %s\n", as.Token.Tok.String(), as.Target.Literal(), as.Value.Literal())
+}
+func (as *AssignmentStatement) String() string {
+	return fmt.Sprintf("(%s = %s)", as.Target.String(), as.Value.String())
+}
+
 type Identifier struct {
 	Token lex.LexedTok
 	Value string
@@ -95,6 +117,66 @@ func (ret *ReturnStatement) String() string {
 	return fmt.Sprintf("(return %s)", ret.ReturnValue.String())
 }
 
+type TypedefStatement struct {
+	Token lex.LexedTok
+	Type  *Type
+	Name  *Identifier
+}
+
+func (t *TypedefStatement) statementNode() {}
+func (t *TypedefStatement) NType() string  { return "TypedefStatement" }
+func (t *TypedefStatement) Literal() string {
+	return fmt.Sprintf("token: %s, type: %s, name: %s\n", t.Token.Tok.String(), t.Type.Literal(), t.Name.Literal())
+}
+func (t *TypedefStatement) String() string {
+	return fmt.Sprintf("(typedef %s %s)", t.Type.String(), t.Name.String())
+}
+
+type StructDefinition struct {
+	Token  lex.LexedTok
+	Name   *Identifier
+	Fields []*Parameter
+}
+
+func (sd *StructDefinition) statementNode() {}
+func (sd *StructDefinition) NType() string  { return "StructDefinition" }
+func (sd *StructDefinition) Literal() string {
+	return fmt.Sprintf("token: %s, name: %s, fields: %s\n", sd.Token.Tok.String(), sd.Name.Literal(), sd.Fields)
+}
+func (sd *StructDefinition) String() string {
+	fs := []string{}
+	for _, f := range sd.Fields {
+		fs = append(fs, f.String())
+	}
+	return fmt.Sprintf("(struct %s {%s})", sd.Name.String(), strings.Join(fs, ", "))
+}
+
+type BreakStatement struct {
+	Token lex.LexedTok
+}
+
+func (b *BreakStatement) statementNode() {}
+func (b *BreakStatement) NType() string  { return "BreakStatement" }
+func (b *BreakStatement) Literal() string {
+	return fmt.Sprintf("token: %s\n", b.Token.Tok.String())
+}
+func (b *BreakStatement) String() string {
+	return "(break)"
+}
+
+type ContinueStatement struct {
+	Token lex.LexedTok
+}
+
+func (c *ContinueStatement) statementNode() {}
+func (c *ContinueStatement) NType() string  { return "ContinueStatement" }
+func (c *ContinueStatement) Literal() string {
+	return fmt.Sprintf("token: %s\n", c.Token.Tok.String())
+}
+func (c *ContinueStatement) String() string {
+	return "(continue)"
+}
+
 type ExpressionStatement struct {
 	Token      lex.LexedTok
 	Expression Expression
@@ -123,6 +205,19 @@ func (i *IntegerLiteral) String() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+type FloatLiteral struct {
+	Token lex.LexedTok
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode() {}
+func (f *FloatLiteral) Literal() string {
+	return fmt.Sprintf("token: %s, value: %g\n", f.Token.Tok.String(), f.Value)
+}
+func (f *FloatLiteral) String() string {
+	return fmt.Sprintf("%g", f.Value)
+}
+
 type StringLiteral struct {
 	Token lex.LexedTok
 	Value string
@@ -165,6 +260,20 @@ func (i *InfixExpression) String() string {
 	return fmt.Sprintf("(%s %s %s)", i.Left.String(), i.Operator, i.Right.String())
 }
 
+type CastExpression struct {
+	Token lex.LexedTok
+	Left  Expression
+	Type  *Type
+}
+
+func (c *CastExpression) expressionNode() {}
+func (c *CastExpression) Literal() string {
+	return fmt.Sprintf("token: %s, left: %s, type: %s\n", c.Token.Tok.String(), c.Left.Literal(), c.Type.Literal())
+}
+func (c *CastExpression) String() string {
+	return fmt.Sprintf("(%s as %s)", c.Left.String(), c.Type.String())
+}
+
 type Boolean struct {
 	Token lex.LexedTok
 	Value bool
@@ -182,6 +291,8 @@ type IfExpression struct {
 	Token       lex.LexedTok
 	Condition   Expression
 	Consequence *BlockStatement
+	// else if branches, tried in order when Condition is false
+	ElseIfs     []*ElseIf
 	Alternative *BlockStatement
 }
 
@@ -189,19 +300,41 @@ func (i *IfExpression) expressionNode() {}
 func (i *IfExpression) statementNode()  {}
 func (i *IfExpression) NType() string   { return "IfExpression" }
 func (i *IfExpression) Literal() string {
-	return fmt.Sprintf("token: %s, condition: %s, consequence: %s, alternative: %s\n", i.Token.Tok.String(), i.Condition.Literal(), i.Consequence.Literal(), i.Alternative.Literal())
+	elseIfs := ""
+	for _, e := range i.ElseIfs {
+		elseIfs += e.Literal()
+	}
+	return fmt.Sprintf("token: %s, condition: %s, consequence: %s, else ifs: %s, alternative: %s\n", i.Token.Tok.String(), i.Condition.Literal(), i.Consequence.Literal(), elseIfs, i.Alternative.Literal())
 }
 func (i *IfExpression) String() string {
+	s := fmt.Sprintf("(if %s %s", i.Condition.String(), i.Consequence.String())
+	for _, e := range i.ElseIfs {
+		s += " " + e.String()
+	}
 	if i.Alternative != nil {
-		return fmt.Sprintf("(if %s %s else %s)", i.Condition.String(), i.Consequence.String(), i.Alternative.String())
+		s += " else " + i.Alternative.String()
 	}
-	return fmt.Sprintf("(if %s %s)", i.Condition.String(), i.Consequence.String())
+	return s + ")"
+}
+
+type ElseIf struct {
+	Token       lex.LexedTok
+	Condition   Expression
+	Consequence *BlockStatement
+}
+
+func (e *ElseIf) Literal() string {
+	return fmt.Sprintf("token: %s, condition: %s, consequence: %s\n", e.Token.Tok.String(), e.Condition.Literal(), e.Consequence.Literal())
+}
+func (e *ElseIf) String() string {
+	return fmt.Sprintf("else if %s %s", e.Condition.String(), e.Consequence.String())
 }
 
 type WhileExpression struct {
-	Token     lex.LexedTok
-	Condition Expression
-	Body      *BlockStatement
+	Token       lex.LexedTok
+	Condition   Expression
+	Body        *BlockStatement
+	Alternative *BlockStatement
 }
 
 func (w *WhileExpression) expressionNode() {}
@@ -211,9 +344,34 @@ func (w *WhileExpression) Literal() string {
 	return fmt.Sprintf("token: %s, condition: %s, body: %s\n", w.Token.Tok.String(), w.Condition.Literal(), w.Body.Literal())
 }
 func (w *WhileExpression) String() string {
+	if w.Alternative != nil {
+		return fmt.Sprintf("(while %s %s else %s)", w.Condition.String(), w.Body.String(), w.Alternative.String())
+	}
 	return fmt.Sprintf("(while %s %s)", w.Condition.String(), w.Body.String())
 }
 
+type ForExpression struct {
+	Token       lex.LexedTok
+	Init        *VarStatement
+	Limit       Expression
+	Step        Expression
+	Body        *BlockStatement
+	Alternative *BlockStatement
+}
+
+func (f *ForExpression) expressionNode() {}
+func (f *ForExpression) statementNode()  {}
+func (f *ForExpression) NType() string   { return "ForExpression" }
+func (f *ForExpression) Literal() string {
+	return fmt.Sprintf("token: %s, init: %s, limit: %s, step: %s, body: %s\n", f.Token.Tok.String(), f.Init.Literal(), f.Limit.Literal(), f.Step.Literal(), f.Body.Literal())
+}
+func (f *ForExpression) String() string {
+	if f.Alternative != nil {
+		return fmt.Sprintf("(for %s, %s, %s %s else %s)", f.Init.String(), f.Limit.String(), f.Step.String(), f.Body.String(), f.Alternative.String())
+	}
+	return fmt.Sprintf("(for %s, %s, %s %s)", f.Init.String(), f.Limit.String(), f.Step.String(), f.Body.String())
+}
+
 type BlockStatement struct {
 	Token      lex.LexedTok
 	Statements []Statement
@@ -258,6 +416,30 @@ func (f *FunctionDefinition) String() string {
 	return fmt.Sprintf("(%s %s (%s) {%s})", f.ReturnType, f.Name.String(), strings.Join(ps, ", "), f.Body.String())
 }
 
+type ExternDeclaration struct {
+	Token      lex.LexedTok
+	ReturnType *Type
+	Name       *Identifier
+	Parameters []*Parameter
+	Variadic   bool
+}
+
+func (e *ExternDeclaration) statementNode() {}
+func (e *ExternDeclaration) NType() string  { return "ExternDeclaration" }
+func (e *ExternDeclaration) Literal() string {
+	return fmt.Sprintf("token: %s, return type: %s, parameters: %s, variadic: %t, name: %s\n", e.Token.Tok.String(), e.ReturnType, e.Parameters, e.Variadic, e.Name.Literal())
+}
+func (e *ExternDeclaration) String() string {
+	ps := []string{}
+	for _, p := range e.Parameters {
+		ps = append(ps, p.String())
+	}
+	if e.Variadic {
+		ps = append(ps, "...")
+	}
+	return fmt.Sprintf("(extern %s %s (%s))", e.ReturnType, e.Name.String(), strings.Join(ps, ", "))
+}
+
 type Parameter struct {
 	Token lex.LexedTok
 	Name  *Identifier
@@ -289,3 +471,31 @@ func (c *CallExpression) String() string {
 	}
 	return fmt.Sprintf("(%s(%s))", c.Function.String(), strings.Join(args, ", "))
 }
+
+type IndexExpression struct {
+	Token lex.LexedTok
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode() {}
+func (ie *IndexExpression) Literal() string {
+	return fmt.Sprintf("token: %s, left: %s, index: %s\n", ie.Token.Tok.String(), ie.Left.Literal(), ie.Index.Literal())
+}
+func (ie *IndexExpression) String() string {
+	return fmt.Sprintf("(%s[%s])", ie.Left.String(), ie.Index.String())
+}
+
+type FieldExpression struct {
+	Token lex.LexedTok
+	Left  Expression
+	Field *Identifier
+}
+
+func (fe *FieldExpression) expressionNode() {}
+func (fe *FieldExpression) Literal() string {
+	return fmt.Sprintf("token: %s, left: %s, field: %s\n", fe.Token.Tok.String(), fe.Left.Literal(), fe.Field.Literal())
+}
+func (fe *FieldExpression) String() string {
+	return fmt.Sprintf("(%s.%s)", fe.Left.String(), fe.Field.String())
+}
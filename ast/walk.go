@@ -0,0 +1,80 @@
+package ast
+
+// Inspect calls f for node and every node below it, parents before children.
+// The bodies of nested function definitions are not entered, as they are generated on their own.
+func Inspect(node Node, f func(Node)) {
+	if node == nil {
+		return
+	}
+	f(node)
+	switch n := node.(type) {
+	case *Program:
+		for _, stmt := range n.Statements {
+			Inspect(stmt, f)
+		}
+	case *BlockStatement:
+		if n == nil {
+			return
+		}
+		for _, stmt := range n.Statements {
+			Inspect(stmt, f)
+		}
+	case *VarStatement:
+		if n.Value != nil {
+			Inspect(n.Value, f)
+		}
+	case *VarReassignmentStatement:
+		Inspect(n.Value, f)
+	case *AssignmentStatement:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			Inspect(n.ReturnValue, f)
+		}
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			Inspect(n.Expression, f)
+		}
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *CastExpression:
+		Inspect(n.Left, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		for _, e := range n.ElseIfs {
+			Inspect(e.Condition, f)
+			Inspect(e.Consequence, f)
+		}
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+	case *WhileExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+	case *ForExpression:
+		Inspect(n.Init, f)
+		Inspect(n.Limit, f)
+		Inspect(n.Step, f)
+		Inspect(n.Body, f)
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+	case *CallExpression:
+		for _, arg := range n.Arguments {
+			Inspect(arg, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *FieldExpression:
+		Inspect(n.Left, f)
+	}
+}
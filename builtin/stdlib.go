@@ -7,7 +7,7 @@ import (
 	"github.com/westsi/dormouse/lex"
 )
 
-func HandleStdlib(prevImps []string, imp string) (*lex.Lexer, []string, map[string]string) {
+func HandleStdlib(prevImps []string, imp string, defines map[string]string) (*lex.Lexer, []string, map[string]string) {
 	for _, i := range prevImps {
 		if i == imp {
 			return nil, []string{}, make(map[string]string)
@@ -21,6 +21,7 @@ func HandleStdlib(prevImps []string, imp string) (*lex.Lexer, []string, map[stri
 	}
 
 	l := lex.NewLexer(reader)
+	l.Defines = defines
 	_, imported, defined := l.Lex()
 	d := make(map[string]string)
 	for k, v := range defined {
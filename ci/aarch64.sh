@@ -25,7 +25,22 @@ while IFS= read -r line; do
         fi
         echo "$inf"
         ./drm -a aarch64 ci/test/$inf.dor
-        ./out/aarch64/$inf
+        ./out/aarch64/$inf > out/aarch64/$inf.stdout
         rc=$?
+        # tests that print have their expected output next to them
+        if [ -f ci/test/$inf.out ] && ! cmp -s out/aarch64/$inf.stdout ci/test/$inf.out; then
+            echo "Test Failed - output differs from ci/test/$inf.out"
+            exit 1
+        fi
     done
 done < ./ci/test/metadata.tests
+
+# programs in ci/test/fail have to be rejected by the compiler
+for test in ci/test/fail/*.dor; do
+    echo "$test"
+    if ./drm -a aarch64 $test > /dev/null; then
+        echo "Test Failed - expected a compile error"
+        exit 1
+    fi
+    echo "Test Succeeded"
+done
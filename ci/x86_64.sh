@@ -24,12 +24,23 @@ while IFS= read -r line; do
             break
         fi
         echo "$inf"
-        if [[ "$inf" == *"arithmetic"* ]]; then
-            # TODO: remove this chunk when division is implemented
-            break
-        fi
         ./drm -a x86_64 ci/test/$inf.dor
-        ./out/x86_64/$inf
+        ./out/x86_64/$inf > out/x86_64/$inf.stdout
         rc=$?
+        # tests that print have their expected output next to them
+        if [ -f ci/test/$inf.out ] && ! cmp -s out/x86_64/$inf.stdout ci/test/$inf.out; then
+            echo "Test Failed - output differs from ci/test/$inf.out"
+            exit 1
+        fi
     done
 done < ./ci/test/metadata.tests
+
+# programs in ci/test/fail have to be rejected by the compiler
+for test in ci/test/fail/*.dor; do
+    echo "$test"
+    if ./drm -a x86_64 $test > /dev/null; then
+        echo "Test Failed - expected a compile error"
+        exit 1
+    fi
+    echo "Test Succeeded"
+done
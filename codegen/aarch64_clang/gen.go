@@ -2,7 +2,9 @@ package aarch64_clang
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -19,12 +21,24 @@ type AARCH64Generator struct {
 	fpath            string
 	out              strings.Builder
 	data             strings.Builder
+	rodata           strings.Builder
 	AST              ast.Program
 	VirtualStack     *util.Armstack[codegen.VTabVar]
 	VirtualRegisters map[StorageLoc]string
 	ConditionCounter int
-	StringCounter    int
 	Gdefs            map[string]string
+	Loops            *util.Stack[codegen.LoopContext]
+	Functions        map[string]codegen.Signature
+	Structs          map[string]codegen.Struct
+	Globals          map[string]string // types of the variables defined outside of functions in any file
+	ReturnType       string
+	ResultPtr        int // slot holding where a struct too big for registers is returned to, 0 if there is none
+	// slots set aside for the results of calls returning structs, and for copies of big struct arguments
+	StructTemps  map[ast.Expression]int
+	BoundsChecks bool
+	TempDepth    int                 // bytes pushed below the locals while working out an expression, which offsets from sp have to skip
+	ReturnLabel  string              // the epilogue of the function being generated, which every return jumps to
+	Saved        map[StorageLoc]bool // callee saved registers used by the function being generated
 }
 
 type StorageLoc int
@@ -65,15 +79,26 @@ const (
 	DATASECT
 )
 
+// CalleeSaved are the registers a function has to give back as it found them.
+var CalleeSaved = []StorageLoc{X19, X20, X21, X22, X23, X24, X25, X26, X27, X28}
+
 var Sls = []StorageLoc{X0, X1, X2, X3, X4, X5, X6, X7, X8, X9, X10, X11, X12, X13, X14, X15, X16, X17, X18, X19, X20, X21, X22, X23, X24, X25, X26, X27, X28}
 
 var StorageLocs = []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7", "x8", "x9", "x10", "x11", "x12", "x13", "x14", "x15", "x16", "x17", "x18", "x19", "x20", "x21", "x22", "x23", "x24", "x25", "x26", "x27", "x28"}
 
+var StorageLocs32 = []string{"w0", "w1", "w2", "w3", "w4", "w5", "w6", "w7", "w8", "w9", "w10", "w11", "w12", "w13", "w14", "w15", "w16", "w17", "w18", "w19", "w20", "w21", "w22", "w23", "w24", "w25", "w26", "w27", "w28"}
+
 var FNCallRegs = []StorageLoc{X0, X1, X2, X3, X4, X5, X6, X7}
 
+// float arguments and return values are passed in v0-v7, separately from the general purpose ones.
+// d is the double precision view of each and s the single precision one.
+var FloatCallRegs = []string{"d0", "d1", "d2", "d3", "d4", "d5", "d6", "d7"}
+
+var FloatCallRegs32 = []string{"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7"}
+
 // https://johannst.github.io/notes/arch/arm64.html
 
-func New(fpath string, ast *ast.Program, defs map[string]string, cc int) *AARCH64Generator {
+func New(fpath string, ast *ast.Program, defs map[string]string, sigs map[string]codegen.Signature, structs map[string]codegen.Struct, globals map[string]string, cc int, boundsChecks bool) *AARCH64Generator {
 	generator := &AARCH64Generator{
 		fpath:            fpath,
 		out:              strings.Builder{},
@@ -82,11 +107,23 @@ func New(fpath string, ast *ast.Program, defs map[string]string, cc int) *AARCH6
 		VirtualStack:     util.NewAStack[codegen.VTabVar](32),
 		VirtualRegisters: map[StorageLoc]string{},
 		ConditionCounter: cc,
-		StringCounter:    0,
 		Gdefs:            defs,
+		Loops:            util.NewStack[codegen.LoopContext](),
+		Functions:        map[string]codegen.Signature{},
+		Structs:          structs,
+		Globals:          globals,
+		BoundsChecks:     boundsChecks,
+		Saved:            map[StorageLoc]bool{},
 	}
 	generator.out.WriteString(".text\n")
 	generator.data.WriteString(".data\n")
+	generator.rodata.WriteString(".section __TEXT,__const\n")
+	for k, v := range codegen.Builtins {
+		generator.Functions[k] = v
+	}
+	for k, v := range sigs {
+		generator.Functions[k] = v
+	}
 	os.MkdirAll("out/aarch64", os.ModePerm)
 	os.MkdirAll("out/aarch64/asm", os.ModePerm)
 	return generator
@@ -94,10 +131,27 @@ func New(fpath string, ast *ast.Program, defs map[string]string, cc int) *AARCH6
 
 func (g *AARCH64Generator) Generate() int {
 	defer tracer.Untrace(tracer.Trace("Generate"))
+	// collect signatures first so calls to functions defined further down can be checked
+	sigs, err := codegen.CollectSignatures(&g.AST)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for k, v := range sigs {
+		g.Functions[k] = v
+	}
 	for _, stmt := range g.AST.Statements {
 		switch stmt := stmt.(type) {
 		case *ast.FunctionDefinition:
+			if stmt.Name.Value == "main" {
+				// every program has one main, so the runtime goes with it
+				g.out.WriteString(runtime)
+			}
 			g.GenerateFunction(stmt)
+		case *ast.VarStatement:
+			g.GenerateGlobal(stmt)
+		case *ast.ExternDeclaration:
+			// nothing to emit, undefined symbols are left for the linker to resolve
 		}
 	}
 	return g.ConditionCounter
@@ -122,27 +176,183 @@ func (g *AARCH64Generator) Write() {
 	if err != nil {
 		panic(err)
 	}
+	_, err = f.WriteString(g.rodata.String())
+	if err != nil {
+		panic(err)
+	}
 }
 
 func (g *AARCH64Generator) GenerateFunction(f *ast.FunctionDefinition) {
 	defer tracer.Untrace(tracer.Trace("GenerateFunction"))
 	oldVirtStack := g.VirtualStack
-	g.VirtualStack = util.NewAStack[codegen.VTabVar](32)
+	oldReturnType := g.ReturnType
+	oldResultPtr, oldStructTemps := g.ResultPtr, g.StructTemps
+	oldReturnLabel, oldSaved := g.ReturnLabel, g.Saved
+	g.VirtualStack = util.NewAStack[codegen.VTabVar](g.FrameSize(f))
 	g.VirtualRegisters = map[StorageLoc]string{}
+	g.ReturnType = f.ReturnType.Value
+	g.ReturnLabel = fmt.Sprintf("LBBreturn%d", g.ConditionCounter)
+	g.ConditionCounter++
+	g.Saved = map[StorageLoc]bool{}
+
+	// the body is generated first, as the prologue saves the callee saved registers it turns out to use
+	before := g.out.String()
+	g.out.Reset()
+	g.ResultPtr = 0
+	if g.PassedByReference(g.ReturnType) {
+		// the caller passes where to write a big struct in x8
+		g.ResultPtr = g.AllocSlots(codegen.VTabVar{Type: "int"}, 1)
+		g.out.WriteString("str x8, [sp, #" + fmt.Sprintf("%d", g.ResultPtr) + "]\n")
+	}
+	g.GenerateParams(f.Parameters)
+	// set aside slots for the results of calls returning structs so they have an address,
+	// and for the copies of big structs passed by reference
+	g.StructTemps = map[ast.Expression]int{}
+	ast.Inspect(f.Body, func(n ast.Node) {
+		c, ok := n.(*ast.CallExpression)
+		if !ok {
+			return
+		}
+		if t := g.FuncType(c.Function.Value); g.Structs[t].Name != "" {
+			g.StructTemps[c] = g.AllocSlots(codegen.VTabVar{Type: t}, g.Slots(t))
+		}
+		for i, arg := range c.Arguments {
+			if params := g.Functions[c.Function.Value].Params; i < len(params) && g.PassedByReference(params[i]) {
+				g.StructTemps[arg] = g.AllocSlots(codegen.VTabVar{Type: params[i]}, g.Slots(params[i]))
+			}
+		}
+	})
+	g.GenerateBlock(f.Body)
+	body := g.out.String()
+	g.out.Reset()
+	g.out.WriteString(before)
 
 	if f.Name.Value == "main" {
 		g.out.WriteString(".globl _main\n")
 	}
 
 	g.out.WriteString("_" + f.Name.Value + ":\n")
-
-	// setup default local stack - TODO: figure out size of stack necessary
-	g.out.WriteString("sub sp, sp, #32\n")
-	// TODO: does storing wzr need to go here?
-	// TODO: implement passing parameters
-	g.GenerateBlock(f.Body)
+	// save x29 (frame pointer) and x30 (link register, holds return address), as calls overwrite them
+	g.out.WriteString("stp x29, x30, [sp, #-16]!\n")
+	g.out.WriteString("mov x29, sp\n")
+	// the callee saved registers go above the locals, and the frame is rounded up so sp stays 16 byte aligned
+	var saved []StorageLoc
+	for _, reg := range CalleeSaved {
+		if g.Saved[reg] {
+			saved = append(saved, reg)
+		}
+	}
+	frame := g.VirtualStack.Size()
+	size := (frame + 8*len(saved) + 15) / 16 * 16
+	if size > 0xfff {
+		g.out.WriteString("sub sp, sp, #" + fmt.Sprintf("%d", size>>12) + ", lsl #12\n")
+	}
+	if size&0xfff > 0 {
+		g.out.WriteString("sub sp, sp, #" + fmt.Sprintf("%d", size&0xfff) + "\n")
+	}
+	for i, reg := range saved {
+		g.out.WriteString("str " + StorageLocs[reg] + ", [sp, #" + fmt.Sprintf("%d", frame+8*i) + "]\n")
+	}
+	g.out.WriteString(body)
+	// every return branches here, and functions returning nothing fall through to it
+	g.out.WriteString(g.ReturnLabel + ":\n")
+	for i, reg := range saved {
+		g.out.WriteString("ldr " + StorageLocs[reg] + ", [sp, #" + fmt.Sprintf("%d", frame+8*i) + "]\n")
+	}
+	g.out.WriteString("mov sp, x29\n")
+	g.out.WriteString("ldp x29, x30, [sp], #16\n")
+	g.out.WriteString("ret\n")
 
 	g.VirtualStack = oldVirtStack
+	g.ReturnType = oldReturnType
+	g.ResultPtr, g.StructTemps = oldResultPtr, oldStructTemps
+	g.ReturnLabel, g.Saved = oldReturnLabel, oldSaved
+	g.VirtualRegisters = map[StorageLoc]string{}
+}
+
+// FrameSize works out how big the virtual stack of f has to be for its parameters, the variables it defines and
+// the struct temporaries of its calls. Slots of scopes that have ended are reused, so this is more than enough.
+func (g *AARCH64Generator) FrameSize(f *ast.FunctionDefinition) int {
+	slots := 2 // the unused slot at offset 0 and the one where a struct too big for registers is returned to
+	for _, param := range f.Parameters {
+		slots += g.Slots(param.Type.Value)
+	}
+	ast.Inspect(f.Body, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.VarStatement:
+			slots += g.Slots(n.Type.Value)
+		case *ast.CallExpression:
+			if t := g.FuncType(n.Function.Value); g.Structs[t].Name != "" {
+				slots += g.Slots(t)
+			}
+			for i := range n.Arguments {
+				if params := g.Functions[n.Function.Value].Params; i < len(params) && g.PassedByReference(params[i]) {
+					slots += g.Slots(params[i])
+				}
+			}
+		}
+	})
+	return slots * 8
+}
+
+// GenerateParams moves the parameters from where the caller passed them into slots of their own.
+func (g *AARCH64Generator) GenerateParams(params []*ast.Parameter) {
+	defer tracer.Untrace(tracer.Trace("GenerateParams"))
+	types := make([]string, len(params))
+	for i, param := range params {
+		types[i] = param.Type.Value
+	}
+	dests, stackOffsets, _ := g.ArgLocations(types, len(types))
+	// arguments passed on the stack start above the saved x29 and x30
+	stackArgs := 16
+	// the argument registers are kept out of the way of the copying until they have all been stored
+	for _, reg := range FNCallRegs {
+		g.VirtualRegisters[reg] = "TEMP"
+	}
+	tmp := g.GetFreeReg("TEMP")
+	for i, param := range params {
+		t := param.Type.Value
+		if codegen.IsArrayType(t) {
+			if err := codegen.CheckArrayType(t); err != nil {
+				g.e(param.Token, err.Error())
+			}
+		}
+		st, isStruct := g.Structs[t]
+		slot := g.AllocSlots(codegen.VTabVar{Name: param.Name.Value, Type: t}, g.Slots(t))
+		switch {
+		case g.PassedByReference(t) && dests[i] != nil:
+			// big structs are passed as the address of a copy the caller made
+			g.CopyMemory(dests[i][0], 0, "sp", slot, st.Size)
+		case g.PassedByReference(t):
+			g.out.WriteString("ldr " + StorageLocs[tmp] + ", [x29, #" + fmt.Sprintf("%d", stackArgs+stackOffsets[i]) + "]\n")
+			g.CopyMemory(StorageLocs[tmp], 0, "sp", slot, st.Size)
+		case isStruct && dests[i] == nil:
+			g.CopyMemory("x29", stackArgs+stackOffsets[i], "sp", slot, st.Size)
+		case isStruct:
+			if _, members, hfa := codegen.HFA(st, g.Structs); hfa {
+				for j, m := range members {
+					g.out.WriteString("str " + dests[i][j] + ", [sp, #" + fmt.Sprintf("%d", slot+m.Offset) + "]\n")
+				}
+				continue
+			}
+			for j, reg := range dests[i] {
+				g.out.WriteString("str " + reg + ", [sp, #" + fmt.Sprintf("%d", slot+j*8) + "]\n")
+			}
+		case dests[i] == nil:
+			// only as many bytes as the type takes up belong to the argument
+			op, reg := "ldr ", StorageLocs[tmp]
+			switch t {
+			case "float":
+				reg = StorageLocs32[tmp]
+			case "bool":
+				op, reg = "ldrb ", StorageLocs32[tmp]
+			}
+			g.out.WriteString(op + reg + ", [x29, #" + fmt.Sprintf("%d", stackArgs+stackOffsets[i]) + "]\n")
+			g.out.WriteString("str " + StorageLocs[tmp] + ", [sp, #" + fmt.Sprintf("%d", slot) + "]\n")
+		default:
+			g.out.WriteString("str " + dests[i][0] + ", [sp, #" + fmt.Sprintf("%d", slot) + "]\n")
+		}
+	}
 	g.VirtualRegisters = map[StorageLoc]string{}
 }
 
@@ -157,21 +367,35 @@ func (g *AARCH64Generator) GenerateExpression(node ast.Expression) StorageLoc {
 		// g.out.WriteString("mov x0, " + fmt.Sprintf("#%d", node.Value) + "\n") //TODO: give this same treatment as the identifier case - picking regs
 		// return X0
 		return g.GenerateIntegerLiteral(node)
+	case *ast.FloatLiteral:
+		return g.GenerateFloatLiteral(node, "double")
+	case *ast.Boolean:
+		return g.GenerateBoolean(node)
+	case *ast.PrefixExpression:
+		return g.GeneratePrefix(node)
 	case *ast.StringLiteral:
 		return g.GenerateStringLiteral(node)
+	case *ast.CastExpression:
+		return g.GenerateCast(node)
 	case *ast.IfExpression:
 		g.GenerateIf(node)
 	case *ast.WhileExpression:
 		g.GenerateWhileLoop(node)
+	case *ast.ForExpression:
+		g.GenerateForLoop(node)
 	case *ast.CallExpression:
-		g.GenerateCall(node)
-		return X0
+		return g.GenerateCall(node)
+	case *ast.IndexExpression:
+		return g.GenerateIndex(node)
+	case *ast.FieldExpression:
+		return g.GenerateField(node)
 	}
 	return NULLSTORAGE
 }
 
 func (g *AARCH64Generator) GenerateBlock(b *ast.BlockStatement) {
 	defer tracer.Untrace(tracer.Trace("GenerateBlock"))
+	scope := g.EnterScope()
 	for _, stmt := range b.Statements {
 		switch stmt := stmt.(type) {
 		case *ast.FunctionDefinition:
@@ -180,23 +404,79 @@ func (g *AARCH64Generator) GenerateBlock(b *ast.BlockStatement) {
 			g.GenerateVarDef(stmt)
 		case *ast.VarReassignmentStatement:
 			g.GenerateVarReassignment(stmt)
+		case *ast.AssignmentStatement:
+			g.GenerateAssignment(stmt)
 		case *ast.ReturnStatement:
 			g.GenerateReturn(stmt)
+		case *ast.BreakStatement:
+			g.GenerateBreak(stmt)
+		case *ast.ContinueStatement:
+			g.GenerateContinue(stmt)
 		case *ast.ExpressionStatement:
 			g.GenerateExpression(stmt.Expression)
 		}
+		// every statement stores its result, so nothing needs to stay in a register
+		g.VirtualRegisters = map[StorageLoc]string{}
 	}
+	g.ExitScope(scope)
+}
+
+// EnterScope snapshots the occupied stack slots so that variables declared after it can be dropped by ExitScope.
+func (g *AARCH64Generator) EnterScope() []codegen.VTabVar {
+	return slices.Clone(g.VirtualStack.Elements)
+}
+
+// ExitScope frees every stack slot filled since the snapshot was taken.
+func (g *AARCH64Generator) ExitScope(scope []codegen.VTabVar) {
+	copy(g.VirtualStack.Elements, scope)
 }
 
 func (g *AARCH64Generator) GenerateReturn(r *ast.ReturnStatement) {
 	defer tracer.Untrace(tracer.Trace("GenerateReturn"))
 	// clean up stack
-	sloc := g.GenerateExpression(r.ReturnValue)
-	if sloc != NULLSTORAGE && sloc != X0 && sloc != DATASECT {
+	if err := codegen.CheckAssign(r.ReturnValue, g.ReturnType, g); err != nil {
+		g.e(r.Token, err.Error())
+	}
+	sloc := g.GenerateConverted(r.ReturnValue, g.ReturnType)
+	st, isStruct := g.Structs[g.ReturnType]
+	switch {
+	case sloc == NULLSTORAGE || sloc == DATASECT:
+	case isStruct:
+		g.GenerateStructReturn(sloc, st)
+	case g.ReturnType == "float":
+		g.out.WriteString("fmov s0, " + StorageLocs32[sloc] + "\n")
+	case g.ReturnType == "double":
+		g.out.WriteString("fmov d0, " + StorageLocs[sloc] + "\n")
+	case sloc != X0:
 		g.out.WriteString("mov " + "x0, " + StorageLocs[sloc] + "\n")
 	}
-	g.out.WriteString("add sp, sp, #32\n")
-	g.out.WriteString("ret\n")
+	g.out.WriteString("b " + g.ReturnLabel + "\n")
+}
+
+// GenerateStructReturn puts the struct at the address in src where the caller expects it,
+// either in registers or copied to the space the caller passed a pointer to in x8.
+func (g *AARCH64Generator) GenerateStructReturn(src StorageLoc, st codegen.Struct) {
+	defer tracer.Untrace(tracer.Trace("GenerateStructReturn"))
+	// nothing else is needed after the return, so the intra procedure call scratch register is safe to use
+	g.out.WriteString("mov x16, " + StorageLocs[src] + "\n")
+	if ft, members, hfa := codegen.HFA(st, g.Structs); hfa {
+		regs := FloatCallRegs
+		if ft == "float" {
+			regs = FloatCallRegs32
+		}
+		for i, m := range members {
+			g.out.WriteString("ldr " + regs[i] + ", [x16, #" + fmt.Sprintf("%d", m.Offset) + "]\n")
+		}
+		return
+	}
+	if g.ResultPtr != 0 {
+		g.out.WriteString("ldr x8, [sp, #" + fmt.Sprintf("%d", g.ResultPtr) + "]\n")
+		g.CopyMemory("x16", 0, "x8", 0, st.Size)
+		return
+	}
+	for off := 0; off < st.Size; off += 8 {
+		g.out.WriteString("ldr " + StorageLocs[off/8] + ", [x16, #" + fmt.Sprintf("%d", off) + "]\n")
+	}
 }
 
 func (g *AARCH64Generator) GenerateIdentifier(i *ast.Identifier) StorageLoc {
@@ -220,6 +500,8 @@ func (g *AARCH64Generator) GenerateIdentifier(i *ast.Identifier) StorageLoc {
 		offset := g.GetVarStackOffset(i.Value)
 		if offset != -1 {
 			return g.LoadIdentFromStack(i, offset)
+		} else if _, ok := g.Globals[i.Value]; ok {
+			return g.LoadGlobal(i)
 		} else {
 			g.e(i.Token, "undefined variable: "+i.Value)
 		}
@@ -227,6 +509,20 @@ func (g *AARCH64Generator) GenerateIdentifier(i *ast.Identifier) StorageLoc {
 	return storageLoc
 }
 
+// GetFreeReg claims the first unused register for owner, which is either a variable name or "TEMP".
+func (g *AARCH64Generator) GetFreeReg(owner string) StorageLoc {
+	for _, v := range Sls {
+		if _, ok := g.VirtualRegisters[v]; !ok {
+			g.VirtualRegisters[v] = owner
+			g.Saved[v] = slices.Contains(CalleeSaved, v)
+			return v
+		}
+	}
+	fmt.Println("Out of registers!")
+	os.Exit(1)
+	return NULLSTORAGE
+}
+
 func (g *AARCH64Generator) GetVarStorageLoc(name string) (StorageLoc, error) {
 	tracer.Trace("GetVarStorageLoc")
 	defer tracer.Untrace("GetVarStorageLoc")
@@ -247,39 +543,176 @@ func (g *AARCH64Generator) GetVarStackOffset(name string) int {
 	tracer.Trace("GetVarStackOffset")
 	defer tracer.Untrace("GetVarStackOffset")
 	// get offset from bottom of stack in terms of indices and multiply by sizes to get bits
-	sizeBelow := 0
-	for _, v := range g.VirtualStack.Elements {
-		switch v.Type {
-		case "int":
-			sizeBelow += 8
+	// slots are addressed by index, as emptied slots from closed scopes leave gaps.
+	// search from the top so that inner scopes shadow outer ones
+	for i := len(g.VirtualStack.Elements) - 1; i >= 0; i-- {
+		if g.VirtualStack.Elements[i].Name == name {
+			return (i + 1) * util.OBJSIZE
 		}
+	}
+	return -1
+}
 
-		if v.Name == name {
-			return sizeBelow
+// VarType implements codegen.Scope.
+func (g *AARCH64Generator) VarType(name string) string {
+	for i := len(g.VirtualStack.Elements) - 1; i >= 0; i-- {
+		if g.VirtualStack.Elements[i].Name == name {
+			return g.VirtualStack.Elements[i].Type
 		}
 	}
-	return -1
+	if t, ok := g.Globals[name]; ok {
+		return t
+	}
+	if _, ok := g.Gdefs[name]; ok {
+		return "int"
+	}
+	return ""
+}
+
+// FuncType implements codegen.Scope.
+func (g *AARCH64Generator) FuncType(name string) string {
+	return g.Functions[name].ReturnType
+}
+
+// StructType implements codegen.Scope.
+func (g *AARCH64Generator) StructType(name string) (codegen.Struct, bool) {
+	st, ok := g.Structs[name]
+	return st, ok
+}
+
+// ByAddress reports whether values of type t are too big for a register and are worked with through their address.
+func (g *AARCH64Generator) ByAddress(t string) bool {
+	_, isStruct := g.Structs[t]
+	return isStruct || codegen.IsArrayType(t) && !codegen.IsArrayRef(t)
+}
+
+// Slots returns how many 8 byte stack slots a value of type t takes up.
+func (g *AARCH64Generator) Slots(t string) int {
+	if st, ok := g.Structs[t]; ok {
+		return (st.Size + 7) / 8
+	}
+	if codegen.IsArrayType(t) && !codegen.IsArrayRef(t) {
+		return codegen.ArrayLen(t)
+	}
+	return 1
+}
+
+// PassedByReference reports whether a value of type t is copied by the caller and passed as a pointer to the copy,
+// which AAPCS64 does for structs bigger than 16 bytes that aren't made of floats.
+func (g *AARCH64Generator) PassedByReference(t string) bool {
+	st, ok := g.Structs[t]
+	if !ok || st.Size <= 16 {
+		return false
+	}
+	_, _, hfa := codegen.HFA(st, g.Structs)
+	return !hfa
 }
 
 func (g *AARCH64Generator) LoadIdentFromStack(i *ast.Identifier, offset int) StorageLoc {
 	tracer.Trace("LoadIdentFromStack")
 	defer tracer.Untrace("LoadIdentFromStack")
-	var reg StorageLoc
-	for _, v := range Sls {
-		_, ok := g.VirtualRegisters[v]
-		if !ok {
-			g.VirtualRegisters[v] = i.Value
-			reg = v
-			break
-		}
+	reg := g.GetFreeReg(i.Value)
+	if g.ByAddress(g.VarType(i.Value)) {
+		// arrays and structs are used through their address
+		g.AddOffset(StorageLocs[reg], "sp", g.TempDepth+offset)
+		return reg
 	}
-	g.out.WriteString("ldr " + StorageLocs[reg] + ", [sp, " + fmt.Sprintf("#%d", offset) + "]\n")
+	g.out.WriteString("ldr " + StorageLocs[reg] + ", [sp, " + fmt.Sprintf("#%d", g.TempDepth+offset) + "]\n")
 	return reg
 }
 
-func (g *AARCH64Generator) GetNextEmptyStackLoc() int {
-	for i := 8; i < g.VirtualStack.Size(); i += 8 {
-		if (g.VirtualStack.Get(i) == codegen.VTabVar{}) {
+// LoadGlobal loads a global variable, or its address if it is used through its address.
+func (g *AARCH64Generator) LoadGlobal(i *ast.Identifier) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("LoadGlobal"))
+	reg := g.GetFreeReg(i.Value)
+	g.out.WriteString("adrp " + StorageLocs[reg] + ", _" + i.Value + "@PAGE\n")
+	g.out.WriteString("add " + StorageLocs[reg] + ", " + StorageLocs[reg] + ", _" + i.Value + "@PAGEOFF\n")
+	if !g.ByAddress(g.VarType(i.Value)) {
+		g.out.WriteString("ldr " + StorageLocs[reg] + ", [" + StorageLocs[reg] + "]\n")
+	}
+	return reg
+}
+
+// AddOffset adds off to the address in base and puts it in dest. add only takes a 12 bit immediate, optionally shifted
+// left by 12, so bigger offsets are added in two parts.
+func (g *AARCH64Generator) AddOffset(dest string, base string, off int) {
+	if off > 0xfff {
+		g.out.WriteString("add " + dest + ", " + base + ", #" + fmt.Sprintf("%d", off>>12) + ", lsl #12\n")
+		base = dest
+	}
+	if off&0xfff != 0 || base != dest {
+		g.out.WriteString("add " + dest + ", " + base + ", #" + fmt.Sprintf("%d", off&0xfff) + "\n")
+	}
+}
+
+// VarLocation returns the base register and offset of the variable called name. Globals are out of reach of an offset
+// from sp, so their address is loaded into a register first.
+func (g *AARCH64Generator) VarLocation(tok lex.LexedTok, name string) (string, int) {
+	if offset := g.GetVarStackOffset(name); offset != -1 {
+		if g.TempDepth+offset <= 0xfff {
+			return "sp", g.TempDepth + offset
+		}
+		// past what ldrb and add can reach from sp, so the address is worked out first
+		reg := g.GetFreeReg("TEMP")
+		g.AddOffset(StorageLocs[reg], "sp", g.TempDepth+offset)
+		return StorageLocs[reg], 0
+	}
+	if _, ok := g.Globals[name]; !ok {
+		g.e(tok, "undefined variable: "+name)
+	}
+	reg := g.GetFreeReg("TEMP")
+	g.out.WriteString("adrp " + StorageLocs[reg] + ", _" + name + "@PAGE\n")
+	g.out.WriteString("add " + StorageLocs[reg] + ", " + StorageLocs[reg] + ", _" + name + "@PAGEOFF\n")
+	return StorageLocs[reg], 0
+}
+
+// GenerateGlobal reserves space for a variable defined outside of any function.
+// Globals with a value go in __data and the rest in __bss, which is zeroed when the program starts.
+func (g *AARCH64Generator) GenerateGlobal(v *ast.VarStatement) {
+	defer tracer.Untrace(tracer.Trace("GenerateGlobal"))
+	t := v.Type.Value
+	if codegen.IsArrayType(t) {
+		if err := codegen.CheckArrayType(t); err != nil {
+			g.e(v.Token, err.Error())
+		}
+	}
+	if _, _, ok := codegen.Layout(t, g.Structs); !ok {
+		g.e(v.Token, "unknown type "+t+" of global "+v.Name.Value)
+	}
+	if t == "string" {
+		// strings point at their literal, which starts out empty if there is none
+		text, err := codegen.GlobalString(v)
+		if err != nil {
+			g.e(v.Token, err.Error())
+		}
+		label := g.StringData(text)
+		g.data.WriteString(".p2align 3\n_" + v.Name.Value + ":\n")
+		g.data.WriteString(".quad " + label + "\n")
+		return
+	}
+	if v.Value == nil {
+		g.data.WriteString(".zerofill __DATA,__bss,_" + v.Name.Value + "," + fmt.Sprintf("%d", g.Slots(t)*8) + ",3\n")
+		return
+	}
+	if g.ByAddress(t) {
+		g.e(v.Token, "global "+v.Name.Value+" of type "+t+" cannot be initialised with a value")
+	}
+	bits, err := codegen.GlobalValue(v)
+	if err != nil {
+		g.e(v.Token, err.Error())
+	}
+	g.data.WriteString(".p2align 3\n_" + v.Name.Value + ":\n")
+	g.data.WriteString(".quad " + fmt.Sprintf("%d", bits) + "\n")
+}
+
+// GetNextEmptyStackLoc finds n empty slots next to each other and returns the offset of the lowest.
+func (g *AARCH64Generator) GetNextEmptyStackLoc(n int) int {
+	for i := 8; i+(n-1)*8 < g.VirtualStack.Size(); i += 8 {
+		free := true
+		for j := 0; j < n; j++ {
+			free = free && (g.VirtualStack.Get(i+j*8) == codegen.VTabVar{})
+		}
+		if free {
 			return i
 		}
 	}
@@ -291,25 +724,354 @@ func (g *AARCH64Generator) GetNextEmptyStackLoc() int {
 func (g *AARCH64Generator) GenerateVarDef(v *ast.VarStatement) {
 	tracer.Trace("GenerateVarDef")
 	defer tracer.Untrace("GenerateVarDef")
-	sloc := g.GenerateExpression(v.Value.(*ast.ExpressionStatement).Expression)
+	if codegen.IsArrayType(v.Type.Value) && !codegen.IsArrayRef(v.Type.Value) {
+		g.GenerateArrayDef(v)
+		return
+	}
+	if st, ok := g.Structs[v.Type.Value]; ok {
+		g.GenerateStructDef(v, st)
+		return
+	}
+	if v.Value == nil && v.Type.Value == "string" {
+		// strings start out empty rather than as a null pointer, so they can always be used
+		v = &ast.VarStatement{Token: v.Token, Name: v.Name, Type: v.Type, Value: &ast.ExpressionStatement{Token: v.Token, Expression: &ast.StringLiteral{Token: v.Token}}}
+	}
+	if v.Value == nil {
+		stackloc := g.AllocSlots(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value}, 1)
+		g.out.WriteString("str xzr, [sp, #" + fmt.Sprintf("%d", stackloc) + "]\n")
+		return
+	}
+	if err := codegen.CheckAssign(v.Value.(*ast.ExpressionStatement).Expression, v.Type.Value, g); err != nil {
+		g.e(v.Token, err.Error())
+	}
+	sloc := g.GenerateConverted(v.Value.(*ast.ExpressionStatement).Expression, v.Type.Value)
 	if sloc == NULLSTORAGE {
 		fmt.Println("\033[31mPROBLEM PANICCCCCCC\033[0m")
 	}
 
-	stackloc := g.GetNextEmptyStackLoc()
+	stackloc := g.GetNextEmptyStackLoc(1)
 	g.VirtualStack.Set(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value}, stackloc)
 
-	switch v.Type.Value {
-	case "int":
+	if codegen.IsScalarType(v.Type.Value) || codegen.IsArrayRef(v.Type.Value) || codegen.IsPointerType(v.Type.Value) || v.Type.Value == "string" {
 		g.out.WriteString("str " + StorageLocs[sloc] + ", [sp, #" + fmt.Sprintf("%d", stackloc) + "]\n")
 	}
 }
 
+// GenerateArrayDef gives a fixed size array a slot for each element and zeroes them.
+func (g *AARCH64Generator) GenerateArrayDef(v *ast.VarStatement) {
+	defer tracer.Untrace(tracer.Trace("GenerateArrayDef"))
+	if v.Value != nil {
+		g.e(v.Token, "arrays cannot be initialised with a value: "+v.Name.Value)
+	}
+	if err := codegen.CheckArrayType(v.Type.Value); err != nil {
+		g.e(v.Token, err.Error())
+	}
+	n := codegen.ArrayLen(v.Type.Value)
+	if n <= 0 {
+		g.e(v.Token, "array length must be positive: "+v.Type.Value)
+	}
+	stackloc := g.AllocSlots(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value}, n)
+	g.ZeroSlots(stackloc, n)
+}
+
+// AllocSlots finds n empty slots next to each other for v and returns the offset of the lowest.
+// The slots after the first are marked as taken without a name.
+func (g *AARCH64Generator) AllocSlots(v codegen.VTabVar, n int) int {
+	stackloc := g.GetNextEmptyStackLoc(n)
+	g.VirtualStack.Set(v, stackloc)
+	for i := 1; i < n; i++ {
+		g.VirtualStack.Set(codegen.VTabVar{Type: v.Type}, stackloc+i*8)
+	}
+	return stackloc
+}
+
+// ZeroSlots zeroes n slots from stackloc upwards.
+func (g *AARCH64Generator) ZeroSlots(stackloc, n int) {
+	addr, count := g.GetFreeReg("TEMP"), g.GetFreeReg("TEMP")
+	label := fmt.Sprintf("LBBzero%d", g.ConditionCounter)
+	g.ConditionCounter++
+	g.AddOffset(StorageLocs[addr], "sp", stackloc)
+	g.out.WriteString("mov " + StorageLocs[count] + ", #" + fmt.Sprintf("%d", n) + "\n")
+	g.out.WriteString(label + ":\n")
+	g.out.WriteString("str xzr, [" + StorageLocs[addr] + "], #8\n")
+	g.out.WriteString("subs " + StorageLocs[count] + ", " + StorageLocs[count] + ", #1\n")
+	g.out.WriteString("b.ne " + label + "\n")
+	delete(g.VirtualRegisters, addr)
+	delete(g.VirtualRegisters, count)
+}
+
+// GenerateStructDef gives a struct enough slots to hold it and copies its value in, or zeroes it if it has none.
+func (g *AARCH64Generator) GenerateStructDef(v *ast.VarStatement, st codegen.Struct) {
+	defer tracer.Untrace(tracer.Trace("GenerateStructDef"))
+	if v.Value == nil {
+		stackloc := g.AllocSlots(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value}, g.Slots(v.Type.Value))
+		g.ZeroSlots(stackloc, g.Slots(v.Type.Value))
+		return
+	}
+	value := v.Value.(*ast.ExpressionStatement).Expression
+	if t := codegen.TypeOf(value, g); t != v.Type.Value {
+		g.e(v.Token, "cannot use "+t+" as "+v.Type.Value)
+	}
+	src := g.GenerateExpression(value)
+	stackloc := g.AllocSlots(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value}, g.Slots(v.Type.Value))
+	g.CopyMemory(StorageLocs[src], 0, "sp", stackloc, st.Size)
+}
+
+// CopyMemory copies size bytes from the address in register src to the address in register dest, at the given offsets.
+func (g *AARCH64Generator) CopyMemory(src string, srcOff int, dest string, destOff int, size int) {
+	defer tracer.Untrace(tracer.Trace("CopyMemory"))
+	// ldrb and strb only reach 4095 bytes from their base, so the addresses of anything further are worked out first
+	if srcOff+size > 0xfff {
+		addr := g.GetFreeReg("TEMP")
+		g.AddOffset(StorageLocs[addr], src, srcOff)
+		src, srcOff = StorageLocs[addr], 0
+		defer delete(g.VirtualRegisters, addr)
+	}
+	if destOff+size > 0xfff {
+		addr := g.GetFreeReg("TEMP")
+		g.AddOffset(StorageLocs[addr], dest, destOff)
+		dest, destOff = StorageLocs[addr], 0
+		defer delete(g.VirtualRegisters, addr)
+	}
+	tmp := g.GetFreeReg("TEMP")
+	for done := 0; done < size; {
+		// copy in the biggest pieces that fit
+		switch {
+		case size-done >= 8:
+			g.out.WriteString(fmt.Sprintf("ldr %s, [%s, #%d]\n", StorageLocs[tmp], src, srcOff+done))
+			g.out.WriteString(fmt.Sprintf("str %s, [%s, #%d]\n", StorageLocs[tmp], dest, destOff+done))
+			done += 8
+		case size-done >= 4:
+			g.out.WriteString(fmt.Sprintf("ldr %s, [%s, #%d]\n", StorageLocs32[tmp], src, srcOff+done))
+			g.out.WriteString(fmt.Sprintf("str %s, [%s, #%d]\n", StorageLocs32[tmp], dest, destOff+done))
+			done += 4
+		default:
+			g.out.WriteString(fmt.Sprintf("ldrb %s, [%s, #%d]\n", StorageLocs32[tmp], src, srcOff+done))
+			g.out.WriteString(fmt.Sprintf("strb %s, [%s, #%d]\n", StorageLocs32[tmp], dest, destOff+done))
+			done++
+		}
+	}
+	delete(g.VirtualRegisters, tmp)
+}
+
+// GenerateElementAddress works out the array and index of ie and returns the memory operand of the element.
+func (g *AARCH64Generator) GenerateElementAddress(ie *ast.IndexExpression) string {
+	defer tracer.Untrace(tracer.Trace("GenerateElementAddress"))
+	t := codegen.TypeOf(ie.Left, g)
+	if !codegen.IsArrayType(t) {
+		g.e(ie.Token, "cannot index "+ie.Left.String()+" of type "+t)
+	}
+	if it := codegen.TypeOf(ie.Index, g); it != "" && !codegen.IsIntegerType(it) {
+		g.e(ie.Token, "array index must be an int, got "+it)
+	}
+	base := g.GenerateExpression(ie.Left)
+	if base == NULLSTORAGE || base == DATASECT {
+		g.e(ie.Token, "array has no value: "+ie.Left.String())
+	}
+	idx := g.GenerateExpression(ie.Index)
+	if idx == NULLSTORAGE || idx == DATASECT {
+		g.e(ie.Token, "index has no value: "+ie.Index.String())
+	}
+	if g.BoundsChecks && codegen.ArrayLen(t) >= 0 {
+		g.GenerateBoundsCheck(ie.Token, idx, codegen.ArrayLen(t))
+	}
+	return "[" + StorageLocs[base] + ", " + StorageLocs[idx] + ", lsl #3]"
+}
+
+// GenerateBoundsCheck aborts the program with the position of tok if idx is not below length.
+func (g *AARCH64Generator) GenerateBoundsCheck(tok lex.LexedTok, idx StorageLoc, length int) {
+	defer tracer.Untrace(tracer.Trace("GenerateBoundsCheck"))
+	msgLabel := fmt.Sprintf("Lbounds%d", g.ConditionCounter)
+	okLabel := fmt.Sprintf("LBBinbounds%d", g.ConditionCounter)
+	g.ConditionCounter++
+	msg := tok.Pos.String() + ": index out of bounds\n"
+	g.data.WriteString(msgLabel + ":\n")
+	g.data.WriteString(".ascii " + strconv.Quote(msg) + "\n")
+
+	// compared unsigned so negative indices are caught too
+	limit := g.GenerateIntegerLiteral(&ast.IntegerLiteral{Token: tok, Value: int64(length)})
+	g.out.WriteString("cmp " + StorageLocs[idx] + ", " + StorageLocs[limit] + "\n")
+	delete(g.VirtualRegisters, limit)
+	g.out.WriteString("b.lo " + okLabel + "\n")
+	// the program ends here, so registers can be clobbered
+	g.out.WriteString("mov x0, #2\n")
+	g.out.WriteString("adrp x1, " + msgLabel + "@PAGE\n")
+	g.out.WriteString("add x1, x1, " + msgLabel + "@PAGEOFF\n")
+	g.out.WriteString("mov x2, #" + fmt.Sprintf("%d", len(msg)) + "\n")
+	g.out.WriteString("bl _write\n")
+	g.out.WriteString("mov x0, #1\n")
+	g.out.WriteString("bl _exit\n")
+	// only reachable from the check, so the registers still hold what they did before it
+	g.out.WriteString(okLabel + ":\n")
+}
+
+func (g *AARCH64Generator) GenerateIndex(ie *ast.IndexExpression) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateIndex"))
+	addr := g.GenerateElementAddress(ie)
+	reg := g.GetFreeReg("TEMP")
+	g.out.WriteString("ldr " + StorageLocs[reg] + ", " + addr + "\n")
+	return reg
+}
+
+// GenerateFieldAddress works out the struct holding the field of fe and returns the memory operand of the field.
+func (g *AARCH64Generator) GenerateFieldAddress(fe *ast.FieldExpression) (string, codegen.Field) {
+	defer tracer.Untrace(tracer.Trace("GenerateFieldAddress"))
+	t := codegen.TypeOf(fe.Left, g)
+	// a pointer to a struct holds the address of the struct, which is what a struct evaluates to anyway
+	if codegen.IsPointerType(t) {
+		t = codegen.PointeeType(t)
+	}
+	st, ok := g.Structs[t]
+	if !ok {
+		g.e(fe.Token, "cannot access field "+fe.Field.Value+" of "+fe.Left.String()+" of type "+t)
+	}
+	f, ok := st.Field(fe.Field.Value)
+	if !ok {
+		g.e(fe.Token, t+" has no field "+fe.Field.Value)
+	}
+	base := g.GenerateExpression(fe.Left)
+	if base == NULLSTORAGE || base == DATASECT {
+		g.e(fe.Token, "struct has no value: "+fe.Left.String())
+	}
+	return fmt.Sprintf("[%s, #%d]", StorageLocs[base], f.Offset), f
+}
+
+func (g *AARCH64Generator) GenerateField(fe *ast.FieldExpression) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateField"))
+	addr, f := g.GenerateFieldAddress(fe)
+	return g.LoadFrom(addr, f.Type)
+}
+
+// LoadFrom loads a value of type t from the memory operand addr, reading only as many bytes as t takes up in a struct.
+func (g *AARCH64Generator) LoadFrom(addr string, t string) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("LoadFrom"))
+	reg := g.GetFreeReg("TEMP")
+	size, _, _ := codegen.Layout(t, g.Structs)
+	switch {
+	case g.ByAddress(t):
+		// the operand is [base, #offset], which add takes without the brackets
+		g.out.WriteString("add " + StorageLocs[reg] + ", " + strings.Trim(addr, "[]") + "\n")
+	case size == 4:
+		g.out.WriteString("ldr " + StorageLocs32[reg] + ", " + addr + "\n")
+	case size == 1:
+		g.out.WriteString("ldrb " + StorageLocs32[reg] + ", " + addr + "\n")
+	default:
+		g.out.WriteString("ldr " + StorageLocs[reg] + ", " + addr + "\n")
+	}
+	return reg
+}
+
+// GeneratePointee works out the pointer p dereferences and returns the memory operand it points to.
+func (g *AARCH64Generator) GeneratePointee(p *ast.PrefixExpression) string {
+	defer tracer.Untrace(tracer.Trace("GeneratePointee"))
+	if t := codegen.TypeOf(p.Right, g); !codegen.IsPointerType(t) {
+		g.e(p.Token, "cannot dereference "+p.Right.String()+" of type "+t)
+	}
+	ptr := g.GenerateExpression(p.Right)
+	if ptr == NULLSTORAGE || ptr == DATASECT {
+		g.e(p.Token, "pointer has no value: "+p.Right.String())
+	}
+	return "[" + StorageLocs[ptr] + ", #0]"
+}
+
+// GenerateAddressOf takes the address of a variable, an array element, a struct field or a dereferenced pointer.
+func (g *AARCH64Generator) GenerateAddressOf(p *ast.PrefixExpression) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateAddressOf"))
+	var addr string
+	switch target := p.Right.(type) {
+	case *ast.Identifier:
+		base, offset := g.VarLocation(target.Token, target.Value)
+		addr = fmt.Sprintf("[%s, #%d]", base, offset)
+	case *ast.IndexExpression:
+		// the element operand has a scaled register, which only add can take apart
+		elem := g.GenerateElementAddress(target)
+		reg := g.GetFreeReg("TEMP")
+		g.out.WriteString("add " + StorageLocs[reg] + ", " + strings.Trim(elem, "[]") + "\n")
+		return reg
+	case *ast.FieldExpression:
+		addr, _ = g.GenerateFieldAddress(target)
+	case *ast.PrefixExpression:
+		if target.Operator != "*" {
+			g.e(p.Token, "cannot take the address of "+p.Right.String())
+		}
+		addr = g.GeneratePointee(target)
+	default:
+		g.e(p.Token, "cannot take the address of "+p.Right.String())
+	}
+	reg := g.GetFreeReg("TEMP")
+	g.out.WriteString("add " + StorageLocs[reg] + ", " + strings.Trim(addr, "[]") + "\n")
+	return reg
+}
+
+// GenerateAssignment stores a value in an array element, a struct field or through a pointer.
+func (g *AARCH64Generator) GenerateAssignment(as *ast.AssignmentStatement) {
+	defer tracer.Untrace(tracer.Trace("GenerateAssignment"))
+	t := codegen.TypeOf(as.Target, g)
+	if vt := codegen.TypeOf(as.Value, g); vt != "" && t != "" && vt != t && !(codegen.IsFloatType(vt) && codegen.IsFloatType(t)) {
+		g.e(as.Token, "cannot assign "+vt+" to "+as.Target.String()+" of type "+t)
+	}
+	if codegen.IsArrayType(t) && !codegen.IsArrayRef(t) {
+		g.e(as.Token, "cannot assign to array "+as.Target.String()+", assign to its elements instead")
+	}
+	sloc := g.GenerateConverted(as.Value, t)
+	if sloc == NULLSTORAGE || sloc == DATASECT {
+		g.e(as.Token, "assigned value has no value: "+as.Value.String())
+	}
+	var addr string
+	size := 8
+	switch target := as.Target.(type) {
+	case *ast.IndexExpression:
+		addr = g.GenerateElementAddress(target)
+	case *ast.FieldExpression:
+		addr, _ = g.GenerateFieldAddress(target)
+		size, _, _ = codegen.Layout(t, g.Structs)
+	case *ast.PrefixExpression:
+		addr = g.GeneratePointee(target)
+		size, _, _ = codegen.Layout(t, g.Structs)
+	}
+	switch {
+	case g.ByAddress(t):
+		dest := g.GetFreeReg("TEMP")
+		g.out.WriteString("add " + StorageLocs[dest] + ", " + strings.Trim(addr, "[]") + "\n")
+		g.CopyMemory(StorageLocs[sloc], 0, StorageLocs[dest], 0, size)
+	case size == 4:
+		g.out.WriteString("str " + StorageLocs32[sloc] + ", " + addr + "\n")
+	case size == 1:
+		g.out.WriteString("strb " + StorageLocs32[sloc] + ", " + addr + "\n")
+	default:
+		g.out.WriteString("str " + StorageLocs[sloc] + ", " + addr + "\n")
+	}
+}
+
 func (g *AARCH64Generator) GenerateInfix(node *ast.InfixExpression) StorageLoc {
 	tracer.Trace("GenerateInfix")
 	defer tracer.Untrace("GenerateInfix")
+	if expr, ok := codegen.PointerArithmetic(node, g); ok {
+		return g.GenerateExpression(expr)
+	}
+	if expr, ok := codegen.StringConcat(node, g); ok {
+		return g.GenerateExpression(expr)
+	}
+	if codegen.IsComparison(node.Operator) {
+		return g.GenerateComparison(node)
+	}
+	if node.Operator == "&&" || node.Operator == "||" {
+		return g.GenerateLogical(node)
+	}
+	if t, isFloat := g.FloatOperandType(node); isFloat {
+		return g.GenerateFloatInfix(node, t)
+	}
 	leftS, rightS, destLoc := g.GetInfixOperands(node)
 
+	if node.Operator == "%" {
+		// there is no remainder instruction, so it is worked out as left - (left / right) * right
+		quotient := g.GetFreeReg("TEMP")
+		g.out.WriteString("sdiv " + StorageLocs[quotient] + ", " + leftS + ", " + rightS + "\n")
+		g.out.WriteString("msub " + StorageLocs[destLoc] + ", " + StorageLocs[quotient] + ", " + rightS + ", " + leftS + "\n")
+		delete(g.VirtualRegisters, quotient)
+		return destLoc
+	}
+
 	switch node.Operator {
 	case "+":
 		g.out.WriteString("add ")
@@ -325,76 +1087,254 @@ func (g *AARCH64Generator) GenerateInfix(node *ast.InfixExpression) StorageLoc {
 		g.out.WriteString("and ")
 	case "|":
 		g.out.WriteString("orr ")
+	case "<<":
+		g.out.WriteString("lsl ")
+	case ">>":
+		g.out.WriteString("asr ")
 	}
 	// fmt.Println(leftS, rightS, destLoc)
 	g.out.WriteString(StorageLocs[destLoc] + ", " + leftS + ", " + rightS + "\n")
 	return destLoc
 }
 
+// FloatOperandType returns the type a float infix expression is worked out in, and false if it is not a float expression.
+func (g *AARCH64Generator) FloatOperandType(node *ast.InfixExpression) (string, bool) {
+	left, right := codegen.TypeOf(node.Left, g), codegen.TypeOf(node.Right, g)
+	if !codegen.IsFloatType(left) && !codegen.IsFloatType(right) {
+		return "", false
+	}
+	t, err := codegen.OperandType(left, right)
+	if err != nil {
+		g.e(node.Token, err.Error())
+	}
+	return t, true
+}
+
+// LoadFloatOperands puts the left operand of node into v30 and the right one into v31, both as type t,
+// and returns the names of the two registers at that precision.
+// Those are used as scratch registers so that they never clobber float arguments being set up for a call.
+func (g *AARCH64Generator) LoadFloatOperands(node *ast.InfixExpression, t string) (string, string) {
+	defer tracer.Untrace(tracer.Trace("LoadFloatOperands"))
+	left := g.GenerateConverted(node.Left, t)
+	right := g.GenerateConverted(node.Right, t)
+	delete(g.VirtualRegisters, left)
+	delete(g.VirtualRegisters, right)
+	if t == "float" {
+		g.out.WriteString("fmov s30, " + StorageLocs32[left] + "\n")
+		g.out.WriteString("fmov s31, " + StorageLocs32[right] + "\n")
+		return "s30", "s31"
+	}
+	g.out.WriteString("fmov d30, " + StorageLocs[left] + "\n")
+	g.out.WriteString("fmov d31, " + StorageLocs[right] + "\n")
+	return "d30", "d31"
+}
+
+func (g *AARCH64Generator) GenerateFloatInfix(node *ast.InfixExpression, t string) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateFloatInfix"))
+	leftS, rightS := g.LoadFloatOperands(node, t)
+	switch node.Operator {
+	case "+":
+		g.out.WriteString("fadd ")
+	case "-":
+		g.out.WriteString("fsub ")
+	case "*":
+		g.out.WriteString("fmul ")
+	case "/":
+		g.out.WriteString("fdiv ")
+	default:
+		g.e(node.Token, "unsupported operator for "+t+": "+node.Operator)
+	}
+	g.out.WriteString(leftS + ", " + leftS + ", " + rightS + "\n")
+	dest := g.GetFreeReg("TEMP")
+	if t == "float" {
+		g.out.WriteString("fmov " + StorageLocs32[dest] + ", " + leftS + "\n")
+	} else {
+		g.out.WriteString("fmov " + StorageLocs[dest] + ", " + leftS + "\n")
+	}
+	return dest
+}
+
 func (g *AARCH64Generator) GetInfixOperands(node *ast.InfixExpression) (string, string, StorageLoc) {
 	tracer.Trace("GetInfixOperands")
 	defer tracer.Untrace("GetInfixOperands")
-	var leftS, rightS string
-	var destLoc StorageLoc
-	switch left := node.Left.(type) {
-	case *ast.Identifier:
-		leftS = StorageLocs[g.GenerateIdentifier(left)]
-	case *ast.InfixExpression:
-		leftS = StorageLocs[g.GenerateInfix(left)]
-	case *ast.CallExpression:
-		g.GenerateCall(left)
-		leftS = StorageLocs[X0]
-	case *ast.IntegerLiteral:
-		leftS = StorageLocs[g.GenerateIntegerLiteral(left)]
+	leftLoc := g.GenerateExpression(node.Left)
+	if leftLoc == NULLSTORAGE || leftLoc == DATASECT {
+		g.e(node.Token, "left operand has no value: "+node.Left.String())
 	}
-
-	switch right := node.Right.(type) {
-	case *ast.Identifier:
-		rightS = StorageLocs[g.GenerateIdentifier(right)]
-	case *ast.IntegerLiteral:
-		// rightS = "#" + fmt.Sprintf("%d", right.Value)
-		rightS = StorageLocs[g.GenerateIntegerLiteral(right)]
-	case *ast.InfixExpression:
-		rightS = StorageLocs[g.GenerateInfix(right)]
-	case *ast.CallExpression:
-		g.GenerateCall(right)
-		rightS = StorageLocs[X0]
+	rightLoc := g.GenerateExpression(node.Right)
+	if rightLoc == NULLSTORAGE || rightLoc == DATASECT {
+		g.e(node.Token, "right operand has no value: "+node.Right.String())
 	}
 
-	for _, v := range Sls {
-		_, ok := g.VirtualRegisters[v]
-		if !ok {
-			g.VirtualRegisters[v] = "TEMP"
-			destLoc = v
-			break
-		}
-	}
-	return leftS, rightS, destLoc
+	destLoc := g.GetFreeReg("TEMP")
+	return StorageLocs[leftLoc], StorageLocs[rightLoc], destLoc
 }
 
 func (g *AARCH64Generator) GenerateIntegerLiteral(il *ast.IntegerLiteral) StorageLoc {
 	defer tracer.Untrace(tracer.Trace("GenerateIntegerLiteral"))
-	var sloc StorageLoc
-	for _, v := range Sls {
-		_, ok := g.VirtualRegisters[v]
-		if !ok {
-			g.VirtualRegisters[v] = "TEMP"
-			sloc = v
-			break
-		}
-	}
+	sloc := g.GetFreeReg("TEMP")
 
 	g.out.WriteString("mov " + StorageLocs[sloc] + ", " + fmt.Sprintf("#%d", il.Value) + "\n")
 
 	return sloc
 }
 
+// GenerateFloatLiteral loads the bit pattern of fl as type t, which is either float or double.
+func (g *AARCH64Generator) GenerateFloatLiteral(fl *ast.FloatLiteral, t string) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateFloatLiteral"))
+	sloc := g.GetFreeReg("TEMP")
+	bits, chunks := math.Float64bits(fl.Value), 4
+	if t == "float" {
+		bits, chunks = uint64(math.Float32bits(float32(fl.Value))), 2
+	}
+	// the bit pattern is built up 16 bits at a time, as mov can only take small immediates
+	g.out.WriteString("movz " + StorageLocs[sloc] + ", " + fmt.Sprintf("#%d", bits&0xffff) + "\n")
+	for i := 1; i < chunks; i++ {
+		g.out.WriteString("movk " + StorageLocs[sloc] + ", " + fmt.Sprintf("#%d, lsl #%d", (bits>>(16*i))&0xffff, 16*i) + "\n")
+	}
+	return sloc
+}
+
+// GenerateConverted generates expr and converts the result between float and double if it is to be used as type to.
+func (g *AARCH64Generator) GenerateConverted(expr ast.Expression, to string) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateConverted"))
+	if fl, ok := expr.(*ast.FloatLiteral); ok && codegen.IsFloatType(to) {
+		return g.GenerateFloatLiteral(fl, to)
+	}
+	from := codegen.TypeOf(expr, g)
+	sloc := g.GenerateExpression(expr)
+	if sloc == NULLSTORAGE || sloc == DATASECT || from == to || !codegen.IsFloatType(from) || !codegen.IsFloatType(to) {
+		return sloc
+	}
+	return g.Convert(sloc, from, to)
+}
+
+// StringData adds s to the read only data, laid out as a string, and returns the label of its first byte.
+func (g *AARCH64Generator) StringData(s string) string {
+	label := fmt.Sprintf("Lstr%d", g.ConditionCounter)
+	g.ConditionCounter++
+	g.rodata.WriteString(".p2align 3\n")
+	g.rodata.WriteString(".quad " + fmt.Sprintf("%d", len(s)) + "\n")
+	g.rodata.WriteString(label + ":\n")
+	g.rodata.WriteString(".ascii " + codegen.AsmString(s+"\x00") + "\n")
+	return label
+}
+
 func (g *AARCH64Generator) GenerateStringLiteral(sl *ast.StringLiteral) StorageLoc {
 	defer tracer.Untrace(tracer.Trace("GenerateStringLiteral"))
-	g.data.WriteString("string" + strconv.Itoa(g.StringCounter) + ":\n")
-	g.StringCounter++
-	g.data.WriteString(".asciz \"" + sl.Value + "\"\n")
-	return DATASECT
+	label := g.StringData(sl.Value)
+	reg := g.GetFreeReg("TEMP")
+	g.out.WriteString("adrp " + StorageLocs[reg] + ", " + label + "@PAGE\n")
+	g.out.WriteString("add " + StorageLocs[reg] + ", " + StorageLocs[reg] + ", " + label + "@PAGEOFF\n")
+	return reg
+}
+
+func (g *AARCH64Generator) GeneratePrefix(p *ast.PrefixExpression) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GeneratePrefix"))
+	switch p.Operator {
+	case "&":
+		return g.GenerateAddressOf(p)
+	case "*":
+		return g.LoadFrom(g.GeneratePointee(p), codegen.TypeOf(p, g))
+	}
+	t := codegen.TypeOf(p.Right, g)
+	src := g.GenerateExpression(p.Right)
+	if src == NULLSTORAGE || src == DATASECT {
+		g.e(p.Token, "operand has no value: "+p.Right.String())
+	}
+	dest := g.GetFreeReg("TEMP")
+	switch {
+	case p.Operator == "!" && t == "bool":
+		// bools are always 0 or 1, so only the lowest bit needs flipping
+		g.out.WriteString("eor " + StorageLocs[dest] + ", " + StorageLocs[src] + ", #1\n")
+	case p.Operator == "!":
+		g.out.WriteString("cmp " + StorageLocs[src] + ", #0\n")
+		g.out.WriteString("cset " + StorageLocs[dest] + ", eq\n")
+	case p.Operator == "-" && t == "float":
+		// negating a float just flips its sign bit
+		g.out.WriteString("eor " + StorageLocs32[dest] + ", " + StorageLocs32[src] + ", #0x80000000\n")
+	case p.Operator == "-" && t == "double":
+		g.out.WriteString("eor " + StorageLocs[dest] + ", " + StorageLocs[src] + ", #0x8000000000000000\n")
+	case p.Operator == "-":
+		g.out.WriteString("neg " + StorageLocs[dest] + ", " + StorageLocs[src] + "\n")
+	case p.Operator == "~" && !codegen.IsFloatType(t):
+		g.out.WriteString("mvn " + StorageLocs[dest] + ", " + StorageLocs[src] + "\n")
+	default:
+		g.e(p.Token, "unsupported operator for "+t+": "+p.Operator)
+	}
+	return dest
+}
+
+func (g *AARCH64Generator) GenerateBoolean(b *ast.Boolean) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateBoolean"))
+	sloc := g.GetFreeReg("TEMP")
+	if b.Value {
+		g.out.WriteString("mov " + StorageLocs[sloc] + ", #1\n")
+	} else {
+		g.out.WriteString("mov " + StorageLocs[sloc] + ", #0\n")
+	}
+	return sloc
+}
+
+func (g *AARCH64Generator) GenerateCast(c *ast.CastExpression) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateCast"))
+	from := codegen.TypeOf(c.Left, g)
+	to := c.Type.Value
+	if !codegen.CastAllowed(from, to) {
+		g.e(c.Token, "cannot cast "+from+" to "+to)
+	}
+	src := g.GenerateExpression(c.Left)
+	if src == NULLSTORAGE || src == DATASECT {
+		g.e(c.Token, "cannot cast expression with no value: "+c.Left.String())
+	}
+	return g.Convert(src, from, to)
+}
+
+// Convert converts the value in src from one scalar type to another, leaving the result in a new register.
+func (g *AARCH64Generator) Convert(src StorageLoc, from, to string) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("Convert"))
+	// floats are kept as their bit pattern in general purpose registers and only moved into s30/d30 to be worked on
+	dest := g.GetFreeReg("TEMP")
+	switch {
+	case from == to || from == "":
+		g.out.WriteString("mov " + StorageLocs[dest] + ", " + StorageLocs[src] + "\n")
+	case to == "bool":
+		switch from {
+		case "float":
+			g.out.WriteString("fmov s30, " + StorageLocs32[src] + "\n")
+			g.out.WriteString("fcmp s30, #0.0\n")
+		case "double":
+			g.out.WriteString("fmov d30, " + StorageLocs[src] + "\n")
+			g.out.WriteString("fcmp d30, #0.0\n")
+		default:
+			g.out.WriteString("cmp " + StorageLocs[src] + ", #0\n")
+		}
+		g.out.WriteString("cset " + StorageLocs[dest] + ", ne\n")
+	case from == "float" && to == "double":
+		g.out.WriteString("fmov s30, " + StorageLocs32[src] + "\n")
+		g.out.WriteString("fcvt d30, s30\n")
+		g.out.WriteString("fmov " + StorageLocs[dest] + ", d30\n")
+	case from == "double" && to == "float":
+		g.out.WriteString("fmov d30, " + StorageLocs[src] + "\n")
+		g.out.WriteString("fcvt s30, d30\n")
+		g.out.WriteString("fmov " + StorageLocs32[dest] + ", s30\n")
+	case from == "float":
+		g.out.WriteString("fmov s30, " + StorageLocs32[src] + "\n")
+		g.out.WriteString("fcvtzs " + StorageLocs[dest] + ", s30\n")
+	case from == "double":
+		g.out.WriteString("fmov d30, " + StorageLocs[src] + "\n")
+		g.out.WriteString("fcvtzs " + StorageLocs[dest] + ", d30\n")
+	case to == "float":
+		g.out.WriteString("scvtf s30, " + StorageLocs[src] + "\n")
+		g.out.WriteString("fmov " + StorageLocs32[dest] + ", s30\n")
+	case to == "double":
+		g.out.WriteString("scvtf d30, " + StorageLocs[src] + "\n")
+		g.out.WriteString("fmov " + StorageLocs[dest] + ", d30\n")
+	default:
+		// between integer types and from bool, which is already 0 or 1
+		g.out.WriteString("mov " + StorageLocs[dest] + ", " + StorageLocs[src] + "\n")
+	}
+	return dest
 }
 
 func (g *AARCH64Generator) GenerateIf(i *ast.IfExpression) {
@@ -402,9 +1342,6 @@ func (g *AARCH64Generator) GenerateIf(i *ast.IfExpression) {
 	defer tracer.Untrace("GenerateIf")
 	// check if condition is true
 	// to do this, check what the comparative expr is and generate the corresponding jump instruction
-	separator := i.Condition.(*ast.InfixExpression).Operator
-	leftS, rightS, _ := g.GetInfixOperands(i.Condition.(*ast.InfixExpression))
-
 	// cmp reg, val
 	// cset reg, operator
 	// tbnz reg, bit number, true label
@@ -425,33 +1362,27 @@ func (g *AARCH64Generator) GenerateIf(i *ast.IfExpression) {
 	// b LBB3
 	// LBB3:
 	// ...
-	trueLabel := fmt.Sprintf("LBBif%dtrue", g.ConditionCounter)
-	falseLabel := fmt.Sprintf("LBBif%dfalse", g.ConditionCounter)
-	endLabel := fmt.Sprintf("LBBif%dend", g.ConditionCounter)
+	count := g.ConditionCounter
+	trueLabel := fmt.Sprintf("LBBif%dtrue", count)
+	falseLabel := fmt.Sprintf("LBBif%dfalse", count)
+	endLabel := fmt.Sprintf("LBBif%dend", count)
 	g.ConditionCounter++
 
-	g.out.WriteString("cmp " + leftS + ", " + rightS + "\n")
-	g.out.WriteString("cset x8, ")
-	switch separator {
-	case "==":
-		g.out.WriteString("eq\n")
-	case "!=":
-		g.out.WriteString("ne\n")
-	case "<":
-		g.out.WriteString("lt\n")
-	case ">":
-		g.out.WriteString("gt\n")
-	case "<=":
-		g.out.WriteString("le\n")
-	case ">=":
-		g.out.WriteString("ge\n")
-	}
-	g.out.WriteString("tbnz x8, #0, " + trueLabel + "\n")
-	g.out.WriteString("b " + falseLabel + "\n")
+	g.GenerateComparisonCheck(i.Token, i.Condition, trueLabel, falseLabel)
 	g.out.WriteString(trueLabel + ":\n")
 	g.GenerateBlock(i.Consequence)
 	g.out.WriteString("b " + endLabel + "\n")
 	g.out.WriteString(falseLabel + ":\n")
+	// each else if is tested where the one before it is false, and the whole chain shares one end label
+	for n, e := range i.ElseIfs {
+		trueLabel := fmt.Sprintf("LBBif%delif%dtrue", count, n)
+		falseLabel := fmt.Sprintf("LBBif%delif%dfalse", count, n)
+		g.GenerateComparisonCheck(e.Token, e.Condition, trueLabel, falseLabel)
+		g.out.WriteString(trueLabel + ":\n")
+		g.GenerateBlock(e.Consequence)
+		g.out.WriteString("b " + endLabel + "\n")
+		g.out.WriteString(falseLabel + ":\n")
+	}
 	if i.Alternative != nil {
 		g.GenerateBlock(i.Alternative)
 	}
@@ -480,38 +1411,219 @@ func (g *AARCH64Generator) GenerateWhileLoop(w *ast.WhileExpression) {
 
 	comparLabel := fmt.Sprintf("LBBwhile%dcompar", g.ConditionCounter)
 	bodyLabel := fmt.Sprintf("LBBwhile%dbody", g.ConditionCounter)
+	elseLabel := fmt.Sprintf("LBBwhile%delse", g.ConditionCounter)
 	endLabel := fmt.Sprintf("LBBwhile%dend", g.ConditionCounter)
+	g.ConditionCounter++
 	g.out.WriteString("b " + comparLabel + "\n")
 	g.out.WriteString(comparLabel + ":\n")
-	g.GenerateComparisonCheck(w.Condition.(*ast.InfixExpression), bodyLabel, endLabel)
+	g.GenerateComparisonCheck(w.Token, w.Condition, bodyLabel, elseLabel)
 	g.out.WriteString(bodyLabel + ":\n")
+	g.Loops.Push(codegen.LoopContext{ContinueLabel: comparLabel, BreakLabel: endLabel})
 	g.GenerateBlock(w.Body)
+	g.Loops.Pop()
 	g.out.WriteString("b " + comparLabel + "\n")
+	// the condition failing means the loop finished without a break
+	g.out.WriteString(elseLabel + ":\n")
+	if w.Alternative != nil {
+		g.GenerateBlock(w.Alternative)
+	}
 	g.out.WriteString(endLabel + ":\n")
+	g.VirtualRegisters = map[StorageLoc]string{}
 }
 
-func (g *AARCH64Generator) GenerateComparisonCheck(c *ast.InfixExpression, trueLab, falseLab string) {
-	defer tracer.Untrace(tracer.Trace("GenerateComparisonCheck"))
-	leftS, rightS, _ := g.GetInfixOperands(c)
+func (g *AARCH64Generator) GenerateForLoop(f *ast.ForExpression) {
+	defer tracer.Untrace(tracer.Trace("GenerateForLoop"))
+	// same layout as a while loop, with the loop variable stored before it and freed after it
+	// 	mov x0, #0
+	// 	str x0, [sp, #8]	; int i = start
+	// 	b LBBfor0compar
+	// LBBfor0compar:
+	// 	...			; i < end
+	// LBBfor0body:
+	// 	...
+	// 	...			; i = i + step
+	// 	b LBBfor0compar
+	// LBBfor0end:
+
+	scope := g.EnterScope()
+	g.GenerateVarDef(f.Init)
+	g.VirtualRegisters = map[StorageLoc]string{}
+
+	comparLabel := fmt.Sprintf("LBBfor%dcompar", g.ConditionCounter)
+	bodyLabel := fmt.Sprintf("LBBfor%dbody", g.ConditionCounter)
+	stepLabel := fmt.Sprintf("LBBfor%dstep", g.ConditionCounter)
+	elseLabel := fmt.Sprintf("LBBfor%delse", g.ConditionCounter)
+	endLabel := fmt.Sprintf("LBBfor%dend", g.ConditionCounter)
+	g.ConditionCounter++
+	loopVar := f.Init.Name
+
+	g.out.WriteString("b " + comparLabel + "\n")
+	g.out.WriteString(comparLabel + ":\n")
+	g.GenerateComparisonCheck(f.Token, codegen.ForCondition(f), bodyLabel, elseLabel)
+	g.out.WriteString(bodyLabel + ":\n")
+	g.Loops.Push(codegen.LoopContext{ContinueLabel: stepLabel, BreakLabel: endLabel})
+	g.GenerateBlock(f.Body)
+	g.Loops.Pop()
+	g.out.WriteString(stepLabel + ":\n")
+	g.VirtualRegisters = map[StorageLoc]string{}
+	g.GenerateVarReassignment(&ast.VarReassignmentStatement{
+		Token: f.Token,
+		Name:  loopVar,
+		Value: &ast.InfixExpression{Token: f.Token, Left: loopVar, Operator: "+", Right: f.Step},
+	})
+	g.out.WriteString("b " + comparLabel + "\n")
+	g.out.WriteString(elseLabel + ":\n")
+	if f.Alternative != nil {
+		g.GenerateBlock(f.Alternative)
+	}
+	g.out.WriteString(endLabel + ":\n")
+	g.VirtualRegisters = map[StorageLoc]string{}
+	g.ExitScope(scope)
+}
+
+func (g *AARCH64Generator) GenerateBreak(b *ast.BreakStatement) {
+	defer tracer.Untrace(tracer.Trace("GenerateBreak"))
+	if g.Loops.Size() == 0 {
+		g.e(b.Token, "break outside of loop")
+	}
+	g.out.WriteString("b " + g.Loops.Peek().BreakLabel + "\n")
+}
+
+func (g *AARCH64Generator) GenerateContinue(c *ast.ContinueStatement) {
+	defer tracer.Untrace(tracer.Trace("GenerateContinue"))
+	if g.Loops.Size() == 0 {
+		g.e(c.Token, "continue outside of loop")
+	}
+	g.out.WriteString("b " + g.Loops.Peek().ContinueLabel + "\n")
+}
+
+// GenerateCompare compares the operands of c and returns the condition code that holds if the comparison does.
+func (g *AARCH64Generator) GenerateCompare(c *ast.InfixExpression) string {
+	defer tracer.Untrace(tracer.Trace("GenerateCompare"))
+	if cmp, ok := codegen.StringComparison(c, g); ok {
+		c = cmp
+	}
+	if t, isFloat := g.FloatOperandType(c); isFloat {
+		leftS, rightS := g.LoadFloatOperands(c, t)
+		g.out.WriteString("fcmp " + leftS + ", " + rightS + "\n")
+		// mi and ls are false when either side is NaN, unlike lt and le
+		switch c.Operator {
+		case "==":
+			return "eq"
+		case "!=":
+			return "ne"
+		case "<":
+			return "mi"
+		case ">":
+			return "gt"
+		case "<=":
+			return "ls"
+		}
+		return "ge"
+	}
+	leftS, rightS, destLoc := g.GetInfixOperands(c)
+	delete(g.VirtualRegisters, destLoc)
 	g.out.WriteString("cmp " + leftS + ", " + rightS + "\n")
-	g.out.WriteString("cset x8, ")
 	switch c.Operator {
 	case "==":
-		g.out.WriteString("eq\n")
+		return "eq"
 	case "!=":
-		g.out.WriteString("ne\n")
+		return "ne"
 	case "<":
-		g.out.WriteString("lt\n")
+		return "lt"
 	case ">":
-		g.out.WriteString("gt\n")
+		return "gt"
 	case "<=":
-		g.out.WriteString("le\n")
-	case ">=":
-		g.out.WriteString("ge\n")
+		return "le"
 	}
-	g.out.WriteString("tbnz x8, #0, " + trueLab + "\n")
+	return "ge"
+}
+
+// GenerateComparison materialises the result of a comparison as 0 or 1.
+func (g *AARCH64Generator) GenerateComparison(c *ast.InfixExpression) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateComparison"))
+	cc := g.GenerateCompare(c)
+	dest := g.GetFreeReg("TEMP")
+	g.out.WriteString("cset " + StorageLocs[dest] + ", " + cc + "\n")
+	return dest
+}
+
+// GenerateLogical materialises the result of && or || as 0 or 1, only working out the right operand if it is needed.
+func (g *AARCH64Generator) GenerateLogical(node *ast.InfixExpression) StorageLoc {
+	defer tracer.Untrace(tracer.Trace("GenerateLogical"))
+	dest := g.GetFreeReg("TEMP")
+	trueLabel := fmt.Sprintf("LBBlogic%dtrue", g.ConditionCounter)
+	falseLabel := fmt.Sprintf("LBBlogic%dfalse", g.ConditionCounter)
+	endLabel := fmt.Sprintf("LBBlogic%dend", g.ConditionCounter)
+	g.ConditionCounter++
+	g.GenerateComparisonCheck(node.Token, node, trueLabel, falseLabel)
+	g.out.WriteString(trueLabel + ":\n")
+	g.out.WriteString("mov " + StorageLocs[dest] + ", #1\n")
+	g.out.WriteString("b " + endLabel + "\n")
+	g.out.WriteString(falseLabel + ":\n")
+	g.out.WriteString("mov " + StorageLocs[dest] + ", #0\n")
+	g.out.WriteString(endLabel + ":\n")
+	g.ForgetVariables()
+	return dest
+}
+
+// ForgetVariables is used after a branch, as variables may not be in the registers they were loaded into
+// on every path, but the temporaries of an enclosing expression are still live.
+func (g *AARCH64Generator) ForgetVariables() {
+	for reg, owner := range g.VirtualRegisters {
+		if owner != "TEMP" {
+			delete(g.VirtualRegisters, reg)
+		}
+	}
+}
+
+// GenerateComparisonCheck branches to trueLab if the condition c of the statement at tok holds and to falseLab if not.
+// Comparisons branch on the flags, && and || skip their right operand if the left one decides the result,
+// and anything else is true if it is not zero.
+func (g *AARCH64Generator) GenerateComparisonCheck(tok lex.LexedTok, c ast.Expression, trueLab, falseLab string) {
+	defer tracer.Untrace(tracer.Trace("GenerateComparisonCheck"))
+	switch cond := c.(type) {
+	case *ast.InfixExpression:
+		switch cond.Operator {
+		case "==", "!=", "<", ">", "<=", ">=":
+			cc := g.GenerateCompare(cond)
+			flag := g.GetFreeReg("TEMP")
+			g.out.WriteString("cset " + StorageLocs[flag] + ", " + cc + "\n")
+			g.out.WriteString("tbnz " + StorageLocs[flag] + ", #0, " + trueLab + "\n")
+			g.out.WriteString("b " + falseLab + "\n")
+			delete(g.VirtualRegisters, flag)
+			g.ForgetVariables()
+			return
+		case "&&":
+			// the right operand is only checked if the left one holds
+			rightLabel := fmt.Sprintf("LBBand%d", g.ConditionCounter)
+			g.ConditionCounter++
+			g.GenerateComparisonCheck(tok, cond.Left, rightLabel, falseLab)
+			g.out.WriteString(rightLabel + ":\n")
+			g.GenerateComparisonCheck(tok, cond.Right, trueLab, falseLab)
+			return
+		case "||":
+			// the right operand is only checked if the left one doesn't hold
+			rightLabel := fmt.Sprintf("LBBor%d", g.ConditionCounter)
+			g.ConditionCounter++
+			g.GenerateComparisonCheck(tok, cond.Left, trueLab, rightLabel)
+			g.out.WriteString(rightLabel + ":\n")
+			g.GenerateComparisonCheck(tok, cond.Right, trueLab, falseLab)
+			return
+		}
+	case *ast.PrefixExpression:
+		if cond.Operator == "!" {
+			g.GenerateComparisonCheck(tok, cond.Right, falseLab, trueLab)
+			return
+		}
+	}
+	sloc := g.GenerateExpression(c)
+	if sloc == NULLSTORAGE || sloc == DATASECT {
+		g.e(tok, "condition has no value: "+c.String())
+	}
+	g.out.WriteString("cbnz " + StorageLocs[sloc] + ", " + trueLab + "\n")
 	g.out.WriteString("b " + falseLab + "\n")
-	g.VirtualRegisters = map[StorageLoc]string{}
+	g.ForgetVariables()
 }
 
 // TODO: nested ifs!!
@@ -519,47 +1631,260 @@ func (g *AARCH64Generator) GenerateComparisonCheck(c *ast.InfixExpression, trueL
 func (g *AARCH64Generator) GenerateVarReassignment(v *ast.VarReassignmentStatement) {
 	tracer.Trace("GenerateVarReassignment")
 	defer tracer.Untrace("GenerateVarReassignment")
-	// find the variable's location in the stack
-	offset := g.GetVarStackOffset(v.Name.Value)
+	t := g.VarType(v.Name.Value)
+	if codegen.IsArrayType(t) && !codegen.IsArrayRef(t) {
+		g.e(v.Token, "cannot assign to array "+v.Name.Value+", assign to its elements instead")
+	}
+	if st, ok := g.Structs[t]; ok {
+		if vt := codegen.TypeOf(v.Value, g); vt != t {
+			g.e(v.Token, "cannot assign "+vt+" to "+v.Name.Value+" of type "+t)
+		}
+		src := g.GenerateExpression(v.Value)
+		base, offset := g.VarLocation(v.Token, v.Name.Value)
+		g.CopyMemory(StorageLocs[src], 0, base, offset, st.Size)
+		return
+	}
+	if err := codegen.CheckAssign(v.Value, t, g); err != nil {
+		g.e(v.Token, err.Error())
+	}
 	// update it with the new value
-	switch val := v.Value.(type) {
-	case *ast.IntegerLiteral:
-		sloc := g.GenerateIntegerLiteral(val)
-		g.out.WriteString("str " + StorageLocs[sloc] + ", " + fmt.Sprintf("[sp, #%d]", offset) + "\n")
-	case *ast.Identifier:
-		g.out.WriteString("str " + StorageLocs[g.GenerateIdentifier(val)] + ", " + fmt.Sprintf("[sp, #%d]", offset) + "\n")
-	case *ast.CallExpression:
-		g.GenerateCall(val)
-		g.out.WriteString("str " + "x0" + ", " + fmt.Sprintf("[sp, #%d]", offset) + "\n")
-	case *ast.InfixExpression:
-		sloc := g.GenerateInfix(v.Value.(*ast.InfixExpression))
-		g.out.WriteString("str " + StorageLocs[sloc] + ", " + fmt.Sprintf("[sp, #%d]", offset) + "\n")
+	sloc := g.GenerateConverted(v.Value, g.VarType(v.Name.Value))
+	if sloc != NULLSTORAGE && sloc != DATASECT {
+		base, offset := g.VarLocation(v.Token, v.Name.Value)
+		g.out.WriteString("str " + StorageLocs[sloc] + ", " + fmt.Sprintf("[%s, #%d]", base, offset) + "\n")
 	}
 	// remove the old value from any registers
-	sloc, _ := g.GetVarStorageLoc(v.Name.Value)
+	sloc, _ = g.GetVarStorageLoc(v.Name.Value)
 	if sloc != NULLSTORAGE && sloc != DATASECT {
 		g.out.WriteString("mov " + StorageLocs[sloc] + ", #0\n")
 		delete(g.VirtualRegisters, sloc)
 	}
 }
 
-func (g *AARCH64Generator) GenerateCall(c *ast.CallExpression) {
+func (g *AARCH64Generator) GenerateCall(c *ast.CallExpression) StorageLoc {
 	tracer.Trace("GenerateCall")
 	defer tracer.Untrace("GenerateCall")
+	pc, err := codegen.PrintCall(c, g)
+	if err != nil {
+		g.e(c.Token, err.Error())
+	}
+	c = pc
+	sig, known := g.Functions[c.Function.Value]
+	if known {
+		if err := codegen.CheckCall(c, sig, g); err != nil {
+			g.e(c.Token, err.Error())
+		}
+	}
+	depth := g.TempDepth
+	// registers in use by an enclosing expression don't survive the call, so save them on the stack
+	saved := g.VirtualRegisters
+	var live []StorageLoc
+	for _, reg := range Sls {
+		if _, ok := saved[reg]; ok {
+			g.Push(reg)
+			live = append(live, reg)
+		}
+	}
+	g.VirtualRegisters = map[StorageLoc]string{}
+
+	// arguments are pushed as they are worked out, as working out a later one could clobber the registers of earlier ones.
+	// structs are pushed as their address
+	var types []string
 	for i, arg := range c.Arguments {
-		sloc := g.GenerateExpression(arg)
-		if sloc != NULLSTORAGE && sloc != DATASECT {
-			g.out.WriteString("mov " + StorageLocs[sloc] + ", " + StorageLocs[FNCallRegs[i]] + "\n")
+		var t string
+		if i < len(sig.Params) {
+			t = sig.Params[i]
+		} else if t = codegen.TypeOf(arg, g); t == "float" {
+			// floats passed as variadic arguments are promoted to doubles, as in C
+			t = "double"
 		}
+		sloc := g.GenerateConverted(arg, t)
+		if sloc == NULLSTORAGE || sloc == DATASECT {
+			g.e(c.Token, "argument has no value: "+arg.String())
+		}
+		g.Push(sloc)
+		delete(g.VirtualRegisters, sloc)
+		types = append(types, t)
+	}
+
+	named := len(types)
+	if sig.Variadic {
+		named = len(sig.Params)
+	}
+	dests, stackOffsets, stackSize := g.ArgLocations(types, named)
+	// sp has to stay 16 byte aligned, so the stack arguments are padded
+	below := (stackSize + 15) / 16 * 16
+	if below > 0 {
+		g.out.WriteString("sub sp, sp, #" + fmt.Sprintf("%d", below) + "\n")
+		g.TempDepth += below
+	}
+	// the pushed value of argument i is above the stack arguments
+	pushed := func(i int) string {
+		return fmt.Sprintf("[sp, #%d]", below+16*(len(types)-1-i))
+	}
+	// the argument registers are taken as they are filled, so they are kept out of the way of the copying
+	for _, reg := range FNCallRegs {
+		g.VirtualRegisters[reg] = "TEMP"
+	}
+	g.VirtualRegisters[X8] = "TEMP"
+	tmp := g.GetFreeReg("TEMP")
+	for i, t := range types {
+		st, isStruct := g.Structs[t]
+		switch {
+		case g.PassedByReference(t):
+			// big structs are copied, and the copy is passed by its address
+			buf := g.TempDepth + g.StructTemps[c.Arguments[i]]
+			g.out.WriteString("ldr " + StorageLocs[tmp] + ", " + pushed(i) + "\n")
+			g.CopyMemory(StorageLocs[tmp], 0, "sp", buf, st.Size)
+			g.AddOffset(StorageLocs[tmp], "sp", buf)
+			if dests[i] != nil {
+				g.out.WriteString("mov " + dests[i][0] + ", " + StorageLocs[tmp] + "\n")
+			} else {
+				g.out.WriteString("str " + StorageLocs[tmp] + ", [sp, #" + fmt.Sprintf("%d", stackOffsets[i]) + "]\n")
+			}
+		case isStruct && dests[i] == nil:
+			g.out.WriteString("ldr " + StorageLocs[tmp] + ", " + pushed(i) + "\n")
+			g.CopyMemory(StorageLocs[tmp], 0, "sp", stackOffsets[i], st.Size)
+		case isStruct:
+			g.out.WriteString("ldr " + StorageLocs[tmp] + ", " + pushed(i) + "\n")
+			offsets := make([]int, len(dests[i]))
+			if _, members, hfa := codegen.HFA(st, g.Structs); hfa {
+				for j, m := range members {
+					offsets[j] = m.Offset
+				}
+			} else {
+				for j := range offsets {
+					offsets[j] = j * 8
+				}
+			}
+			for j, reg := range dests[i] {
+				g.out.WriteString("ldr " + reg + ", [" + StorageLocs[tmp] + ", #" + fmt.Sprintf("%d", offsets[j]) + "]\n")
+			}
+		case dests[i] == nil:
+			g.out.WriteString("ldr " + StorageLocs[tmp] + ", " + pushed(i) + "\n")
+			op, reg := "str ", StorageLocs[tmp]
+			if i < named {
+				// named arguments on the stack only take up their own size
+				switch t {
+				case "float":
+					reg = StorageLocs32[tmp]
+				case "bool":
+					op, reg = "strb ", StorageLocs32[tmp]
+				}
+			}
+			g.out.WriteString(op + reg + ", [sp, #" + fmt.Sprintf("%d", stackOffsets[i]) + "]\n")
+		default:
+			g.out.WriteString("ldr " + dests[i][0] + ", " + pushed(i) + "\n")
+		}
+	}
+	result, returnsStruct := g.Structs[sig.ReturnType]
+	if returnsStruct && g.PassedByReference(sig.ReturnType) {
+		// x8 holds where to write a big struct
+		g.AddOffset("x8", "sp", g.TempDepth+g.StructTemps[c])
 	}
-	// save x29 (frame pointer) and x30 (link register, holds return address) to stack before calling and potentially overwriting them
-	g.out.WriteString("stp x29, x30, [sp, #16]\n")
-	g.out.WriteString("add x29, sp, #16\n")
 	g.out.WriteString("bl _" + c.Function.Value + "\n")
-	g.out.WriteString("ldp x29, x30, [sp, #16]\n")
+	g.out.WriteString("add sp, sp, #" + fmt.Sprintf("%d", below+16*len(types)) + "\n")
+	g.TempDepth -= below + 16*len(types)
+	// float results come back in v0 but callers expect every result in x0
+	switch sig.ReturnType {
+	case "float":
+		g.out.WriteString("fmov w0, s0\n")
+	case "double":
+		g.out.WriteString("fmov x0, d0\n")
+	}
+	if returnsStruct {
+		// structs that come back in registers are written out to give them an address
+		buf := g.TempDepth + g.StructTemps[c]
+		if ft, members, hfa := codegen.HFA(result, g.Structs); hfa {
+			regs := FloatCallRegs
+			if ft == "float" {
+				regs = FloatCallRegs32
+			}
+			for i, m := range members {
+				g.out.WriteString("str " + regs[i] + ", [sp, #" + fmt.Sprintf("%d", buf+m.Offset) + "]\n")
+			}
+		} else if !g.PassedByReference(sig.ReturnType) {
+			for off := 0; off < result.Size; off += 8 {
+				g.out.WriteString("str " + StorageLocs[off/8] + ", [sp, #" + fmt.Sprintf("%d", buf+off) + "]\n")
+			}
+		}
+		g.AddOffset("x0", "sp", buf)
+	}
 
-	for sl := range g.VirtualRegisters {
-		delete(g.VirtualRegisters, sl)
+	// restore the saved registers, keeping the result out of their way
+	g.VirtualRegisters = map[StorageLoc]string{}
+	for _, reg := range live {
+		g.VirtualRegisters[reg] = saved[reg]
+	}
+	dest := g.GetFreeReg("TEMP")
+	if dest != X0 {
+		g.out.WriteString("mov " + StorageLocs[dest] + ", x0\n")
+	}
+	for i := len(live) - 1; i >= 0; i-- {
+		g.Pop(live[i])
+	}
+	g.TempDepth = depth
+	return dest
+}
+
+// Push saves reg below the locals. Each value takes 16 bytes, as sp has to stay 16 byte aligned.
+func (g *AARCH64Generator) Push(reg StorageLoc) {
+	g.out.WriteString("str " + StorageLocs[reg] + ", [sp, #-16]!\n")
+	g.TempDepth += 16
+}
+
+// Pop restores reg from the last value pushed.
+func (g *AARCH64Generator) Pop(reg StorageLoc) {
+	g.out.WriteString("ldr " + StorageLocs[reg] + ", [sp], #16\n")
+	g.TempDepth -= 16
+}
+
+// ArgLocations works out where AAPCS64, as Apple uses it, passes arguments of the given types when the first named
+// are named parameters. It returns the registers each goes in, or nil and its offset into the stack arguments,
+// and the size of the stack arguments. Arguments that don't fit in the registers left go on the stack, packed to
+// their own alignment, and variadic arguments always go on the stack in 8 byte slots.
+func (g *AARCH64Generator) ArgLocations(types []string, named int) ([][]string, []int, int) {
+	dests := make([][]string, len(types))
+	stackOffsets := make([]int, len(types))
+	intArgs, floatArgs, stackSize := 0, 0, 0
+	intRegs := StorageLocs[:len(FNCallRegs)]
+	for i, t := range types {
+		size, align, _ := codegen.Layout(t, g.Structs)
+		// how many registers the argument takes from which bank
+		bank, taken, n := intRegs, &intArgs, 1
+		st, isStruct := g.Structs[t]
+		switch {
+		case i >= named:
+			size, align, n = (size+7)/8*8, 8, 0
+		case g.PassedByReference(t):
+			size, align = 8, 8
+		case isStruct:
+			if ft, members, hfa := codegen.HFA(st, g.Structs); hfa {
+				bank, taken, n = FloatCallRegs, &floatArgs, len(members)
+				if ft == "float" {
+					bank = FloatCallRegs32
+				}
+			} else {
+				n = (size + 7) / 8
+			}
+			size, align = (size+7)/8*8, 8
+		case t == "float":
+			bank, taken = FloatCallRegs32, &floatArgs
+		case t == "double":
+			bank, taken = FloatCallRegs, &floatArgs
+		}
+		if n > 0 && *taken+n <= len(bank) {
+			dests[i] = bank[*taken : *taken+n]
+			*taken += n
+			continue
+		}
+		if n > 0 {
+			// once an argument has gone on the stack, later ones can't go in the registers left
+			*taken = len(bank)
+		}
+		stackSize = (stackSize + align - 1) / align * align
+		stackOffsets[i] = stackSize
+		stackSize += size
 	}
-	g.VirtualRegisters[X0] = "CALLRET"
+	return dests, stackOffsets, stackSize
 }
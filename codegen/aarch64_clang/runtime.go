@@ -0,0 +1,124 @@
+package aarch64_clang
+
+// runtime implements codegen.Builtins. It is emitted once, alongside main.
+// Strings made by concat are allocated with malloc and never freed. Everything is printed with printf, so output
+// from print shares a buffer with calls to printf from the program and comes out in order.
+// On Apple platforms variadic arguments always go on the stack, which is where printf finds the value.
+const runtime = `.p2align 2
+_len:
+ldur x0, [x0, #-8]
+ret
+.p2align 2
+_compare:
+stp x29, x30, [sp, #-32]!
+mov x29, sp
+stp x19, x20, [sp, #16]
+ldur x19, [x0, #-8]
+ldur x20, [x1, #-8]
+cmp x19, x20
+csel x2, x19, x20, lo
+bl _memcmp
+sxtw x0, w0
+cbnz x0, Lcompare_done
+sub x0, x19, x20
+Lcompare_done:
+ldp x19, x20, [sp, #16]
+ldp x29, x30, [sp], #32
+ret
+.p2align 2
+_concat:
+stp x29, x30, [sp, #-48]!
+mov x29, sp
+stp x19, x20, [sp, #16]
+str x21, [sp, #32]
+mov x19, x0
+mov x20, x1
+ldur x21, [x0, #-8]
+ldur x9, [x1, #-8]
+add x21, x21, x9
+add x0, x21, #9
+bl _malloc
+str x21, [x0], #8
+mov x21, x0
+mov x1, x19
+ldur x2, [x19, #-8]
+bl _memcpy
+ldur x9, [x19, #-8]
+add x0, x21, x9
+mov x1, x20
+ldur x2, [x20, #-8]
+add x2, x2, #1
+bl _memcpy
+mov x0, x21
+ldr x21, [sp, #32]
+ldp x19, x20, [sp, #16]
+ldp x29, x30, [sp], #48
+ret
+.p2align 2
+_print_string:
+adrp x9, Lfmt_string@PAGE
+add x9, x9, Lfmt_string@PAGEOFF
+adrp x10, Lfmt_stringln@PAGE
+add x10, x10, Lfmt_stringln@PAGEOFF
+b Lprint
+.p2align 2
+_print_int:
+adrp x9, Lfmt_int@PAGE
+add x9, x9, Lfmt_int@PAGEOFF
+adrp x10, Lfmt_intln@PAGE
+add x10, x10, Lfmt_intln@PAGEOFF
+Lprint:
+sub sp, sp, #32
+stp x29, x30, [sp, #16]
+add x29, sp, #16
+str x0, [sp]
+tst w1, #0xff
+csel x0, x10, x9, ne
+bl _printf
+ldp x29, x30, [sp, #16]
+add sp, sp, #32
+ret
+.p2align 2
+_print_float:
+sub sp, sp, #32
+stp x29, x30, [sp, #16]
+add x29, sp, #16
+str d0, [sp]
+adrp x9, Lfmt_float@PAGE
+add x9, x9, Lfmt_float@PAGEOFF
+adrp x10, Lfmt_floatln@PAGE
+add x10, x10, Lfmt_floatln@PAGEOFF
+tst w0, #0xff
+csel x0, x10, x9, ne
+bl _printf
+ldp x29, x30, [sp, #16]
+add sp, sp, #32
+ret
+.p2align 2
+_print_bool:
+adrp x9, Ltrue@PAGE
+add x9, x9, Ltrue@PAGEOFF
+adrp x10, Lfalse@PAGE
+add x10, x10, Lfalse@PAGEOFF
+tst w0, #0xff
+csel x0, x9, x10, ne
+b _print_string
+.section __TEXT,__cstring
+Lfmt_string:
+.asciz "%s"
+Lfmt_stringln:
+.asciz "%s\n"
+Lfmt_int:
+.asciz "%lld"
+Lfmt_intln:
+.asciz "%lld\n"
+Lfmt_float:
+.asciz "%g"
+Lfmt_floatln:
+.asciz "%g\n"
+Ltrue:
+.asciz "true"
+Lfalse:
+.asciz "false"
+.text
+`
@@ -1,5 +1,7 @@
 package codegen
 
+import "github.com/westsi/dormouse/ast"
+
 type CodeGenerator interface {
 	Generate() int
 	Write()
@@ -9,3 +11,28 @@ type VTabVar struct {
 	Name string
 	Type string
 }
+
+// LoopContext holds the jump targets of an enclosing loop for break and continue.
+type LoopContext struct {
+	ContinueLabel string
+	BreakLabel    string
+}
+
+// ForCondition returns the condition a for loop keeps going while. It counts up to its limit, or down to it when
+// stepping by a negative constant. The sign of any other step is only known when the loop runs, so it is tested then.
+func ForCondition(f *ast.ForExpression) ast.Expression {
+	loopVar := f.Init.Name
+	cond := func(left ast.Expression, op string, right ast.Expression) ast.Expression {
+		return &ast.InfixExpression{Token: f.Token, Left: left, Operator: op, Right: right}
+	}
+	if step, ok := f.Step.(*ast.IntegerLiteral); ok {
+		if step.Value < 0 {
+			return cond(loopVar, ">", f.Limit)
+		}
+		return cond(loopVar, "<", f.Limit)
+	}
+	zero := &ast.IntegerLiteral{Token: f.Token, Value: 0}
+	down := cond(cond(f.Step, "<", zero), "&&", cond(loopVar, ">", f.Limit))
+	up := cond(cond(f.Step, ">=", zero), "&&", cond(loopVar, "<", f.Limit))
+	return cond(down, "||", up)
+}
@@ -0,0 +1,74 @@
+package codegen
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/westsi/dormouse/ast"
+)
+
+// CollectGlobals finds every variable defined at the top level of programs and returns their types by name.
+// Globals are visible from every file, so each can only be defined once.
+func CollectGlobals(programs []*ast.Program) (map[string]string, error) {
+	globals := make(map[string]string)
+	for _, program := range programs {
+		if program == nil {
+			continue
+		}
+		for _, stmt := range program.Statements {
+			if v, ok := stmt.(*ast.VarStatement); ok {
+				if _, ok := globals[v.Name.Value]; ok {
+					return nil, fmt.Errorf("%v: global %s is defined more than once", v.Token.Pos, v.Name.Value)
+				}
+				globals[v.Name.Value] = v.Type.Value
+			}
+		}
+	}
+	return globals, nil
+}
+
+// GlobalValue returns the bit pattern a global of type t starts out holding.
+// Nothing runs before main to work out an expression, so globals can only be initialised with literals.
+func GlobalValue(v *ast.VarStatement) (uint64, error) {
+	t := v.Type.Value
+	value := v.Value
+	if es, ok := value.(*ast.ExpressionStatement); ok {
+		value = es.Expression
+	}
+	switch value := value.(type) {
+	case *ast.IntegerLiteral:
+		if IsIntegerType(t) || IsPointerType(t) {
+			return uint64(value.Value), nil
+		}
+	case *ast.FloatLiteral:
+		if t == "float" {
+			return uint64(math.Float32bits(float32(value.Value))), nil
+		}
+		if t == "double" {
+			return math.Float64bits(value.Value), nil
+		}
+	case *ast.Boolean:
+		if t == "bool" && value.Value {
+			return 1, nil
+		}
+		if t == "bool" {
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("global %s of type %s can only be initialised with a literal of that type", v.Name.Value, t)
+}
+
+// GlobalString returns the text a global string starts out holding, which is empty if it has no value.
+func GlobalString(v *ast.VarStatement) (string, error) {
+	if v.Value == nil {
+		return "", nil
+	}
+	value := v.Value
+	if es, ok := value.(*ast.ExpressionStatement); ok {
+		value = es.Expression
+	}
+	if lit, ok := value.(*ast.StringLiteral); ok {
+		return lit.Value, nil
+	}
+	return "", fmt.Errorf("global %s of type string can only be initialised with a literal of that type", v.Name.Value)
+}
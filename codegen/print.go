@@ -0,0 +1,40 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/westsi/dormouse/ast"
+)
+
+// PrintCall rewrites print and println, which take a value of any printable type, into a call to the runtime
+// function that prints that type. Calls to anything else are returned as they are.
+func PrintCall(c *ast.CallExpression, s Scope) (*ast.CallExpression, error) {
+	name := c.Function.Value
+	if name != "print" && name != "println" {
+		return c, nil
+	}
+	if len(c.Arguments) != 1 {
+		return nil, fmt.Errorf("%s takes 1 argument, got %d", name, len(c.Arguments))
+	}
+	var fn string
+	arg := c.Arguments[0]
+	switch t := TypeOf(arg, s); {
+	case t == "string":
+		fn = "print_string"
+	case IsIntegerType(t):
+		fn = "print_int"
+	case t == "float":
+		// floats are printed as doubles, as printf does
+		fn = "print_float"
+		arg = &ast.CastExpression{Token: c.Token, Left: arg, Type: &ast.Type{Token: c.Token, Value: "double"}}
+	case t == "double":
+		fn = "print_float"
+	case t == "bool":
+		fn = "print_bool"
+	default:
+		return nil, fmt.Errorf("%s cannot print a value of type %s", name, t)
+	}
+	newline := &ast.Boolean{Token: c.Token, Value: name == "println"}
+	callee := &ast.Identifier{Token: c.Function.Token, Value: fn}
+	return &ast.CallExpression{Token: c.Token, Function: callee, Arguments: []ast.Expression{arg, newline}}, nil
+}
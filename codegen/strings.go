@@ -0,0 +1,76 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/westsi/dormouse/ast"
+)
+
+// Strings are a pointer to their bytes, which are followed by a 0 so they can be passed to C as they are.
+// The length is kept in the 8 bytes before the first byte.
+
+// Builtins are the functions every program can call. They are part of the runtime emitted alongside main.
+var Builtins = map[string]Signature{
+	// the number of bytes in a string
+	"len": {ReturnType: "int", Params: []string{"string"}},
+	// a new string holding both strings one after the other
+	"concat": {ReturnType: "string", Params: []string{"string", "string"}},
+	// less than, equal to or greater than 0 as the first string sorts before, the same as or after the second
+	"compare": {ReturnType: "int", Params: []string{"string", "string"}},
+	// print and println are turned into calls to these, which print their value with a newline after it if asked
+	"print_string": {ReturnType: "void", Params: []string{"string", "bool"}},
+	"print_int":    {ReturnType: "void", Params: []string{"int", "bool"}},
+	"print_float":  {ReturnType: "void", Params: []string{"double", "bool"}},
+	"print_bool":   {ReturnType: "void", Params: []string{"bool", "bool"}},
+}
+
+// IsBuiltin reports whether name is taken by a builtin, or by print and println which are turned into calls to them.
+func IsBuiltin(name string) bool {
+	_, ok := Builtins[name]
+	return ok || name == "print" || name == "println"
+}
+
+// StringConcat rewrites adding two strings into a call to concat. It returns false if node does not add strings.
+func StringConcat(node *ast.InfixExpression, s Scope) (ast.Expression, bool) {
+	if node.Operator != "+" || TypeOf(node.Left, s) != "string" || TypeOf(node.Right, s) != "string" {
+		return nil, false
+	}
+	return builtinCall(node, "concat"), true
+}
+
+// StringComparison rewrites comparing two strings into comparing the result of compare with 0.
+// It returns false if node does not compare strings.
+func StringComparison(node *ast.InfixExpression, s Scope) (*ast.InfixExpression, bool) {
+	if !IsComparison(node.Operator) || TypeOf(node.Left, s) != "string" || TypeOf(node.Right, s) != "string" {
+		return nil, false
+	}
+	zero := &ast.IntegerLiteral{Token: node.Token, Value: 0}
+	return &ast.InfixExpression{Token: node.Token, Left: builtinCall(node, "compare"), Operator: node.Operator, Right: zero}, true
+}
+
+func builtinCall(node *ast.InfixExpression, name string) *ast.CallExpression {
+	fn := &ast.Identifier{Token: node.Token, Value: name}
+	return &ast.CallExpression{Token: node.Token, Function: fn, Arguments: []ast.Expression{node.Left, node.Right}}
+}
+
+// AsmString quotes s for an .ascii directive. Anything other than printable ASCII is written as an octal escape,
+// which both the GNU and LLVM assemblers understand.
+func AsmString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= ' ' && c <= '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\%03o", c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
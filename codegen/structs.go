@@ -0,0 +1,177 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/westsi/dormouse/ast"
+)
+
+// Field is a member of a struct along with where it sits in the struct.
+type Field struct {
+	Name   string
+	Type   string
+	Offset int
+}
+
+// Struct describes how a struct type is laid out in memory.
+type Struct struct {
+	Name   string
+	Fields []Field
+	Size   int
+	Align  int
+}
+
+// Field looks up the field called name.
+func (s Struct) Field(name string) (Field, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// CollectStructs lays out every struct defined at the top level of programs.
+// Structs can contain structs from any of the programs, as long as none contains itself.
+func CollectStructs(programs []*ast.Program) (map[string]Struct, error) {
+	defs := make(map[string]*ast.StructDefinition)
+	for _, program := range programs {
+		if program == nil {
+			continue
+		}
+		for _, stmt := range program.Statements {
+			if sd, ok := stmt.(*ast.StructDefinition); ok {
+				if _, ok := defs[sd.Name.Value]; ok {
+					return nil, fmt.Errorf("%v: struct %s is defined more than once", sd.Token.Pos, sd.Name.Value)
+				}
+				defs[sd.Name.Value] = sd
+			}
+		}
+	}
+	structs := make(map[string]Struct)
+	for name := range defs {
+		if _, err := layoutStruct(name, defs, structs, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return structs, nil
+}
+
+// layoutStruct works out the offset of each field of the struct called name, laying out the structs it contains first.
+func layoutStruct(name string, defs map[string]*ast.StructDefinition, structs map[string]Struct, visiting map[string]bool) (Struct, error) {
+	if s, ok := structs[name]; ok {
+		return s, nil
+	}
+	sd := defs[name]
+	if visiting[name] {
+		return Struct{}, fmt.Errorf("%v: struct %s contains itself", sd.Token.Pos, name)
+	}
+	visiting[name] = true
+	if len(sd.Fields) == 0 {
+		return Struct{}, fmt.Errorf("%v: struct %s has no fields", sd.Token.Pos, name)
+	}
+	s := Struct{Name: name, Align: 1}
+	for _, f := range sd.Fields {
+		if _, ok := s.Field(f.Name.Value); ok {
+			return Struct{}, fmt.Errorf("%v: struct %s has more than one field called %s", f.Token.Pos, name, f.Name.Value)
+		}
+		t := f.Type.Value
+		if _, ok := defs[t]; ok {
+			if _, err := layoutStruct(t, defs, structs, visiting); err != nil {
+				return Struct{}, err
+			}
+		}
+		size, align, ok := Layout(t, structs)
+		if !ok {
+			return Struct{}, fmt.Errorf("%v: field %s of struct %s has unknown type %s", f.Token.Pos, f.Name.Value, name, t)
+		}
+		s.Size = alignTo(s.Size, align)
+		s.Fields = append(s.Fields, Field{Name: f.Name.Value, Type: t, Offset: s.Size})
+		s.Size += size
+		s.Align = max(s.Align, align)
+	}
+	// padded at the end so the fields stay aligned when one struct follows another
+	s.Size = alignTo(s.Size, s.Align)
+	structs[name] = s
+	return s, nil
+}
+
+func alignTo(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// Layout returns the size and alignment of a value of type t inside a struct.
+// Array elements always take 8 bytes, as they do on the stack.
+func Layout(t string, structs map[string]Struct) (int, int, bool) {
+	switch {
+	case t == "float":
+		return 4, 4, true
+	case t == "bool":
+		return 1, 1, true
+	case IsScalarType(t), t == "string", IsArrayRef(t), IsPointerType(t):
+		return 8, 8, true
+	case IsArrayType(t) && (IsScalarType(ElementType(t)) || IsPointerType(ElementType(t))):
+		return ArrayLen(t) * 8, 8, true
+	}
+	if s, ok := structs[t]; ok {
+		return s.Size, s.Align, true
+	}
+	return 0, 0, false
+}
+
+// Scalars flattens a value of type t into the single register values it is made of,
+// looking inside nested structs and arrays. Offsets are from the start of the value.
+func Scalars(t string, structs map[string]Struct) []Field {
+	if s, ok := structs[t]; ok {
+		fields := []Field{}
+		for _, f := range s.Fields {
+			for _, inner := range Scalars(f.Type, structs) {
+				inner.Offset += f.Offset
+				fields = append(fields, inner)
+			}
+		}
+		return fields
+	}
+	if IsArrayType(t) && !IsArrayRef(t) {
+		fields := []Field{}
+		for i := 0; i < ArrayLen(t); i++ {
+			fields = append(fields, Field{Type: ElementType(t), Offset: i * 8})
+		}
+		return fields
+	}
+	return []Field{{Type: t}}
+}
+
+// ClassifySysV returns the register class of each eightbyte of s under the System V x86_64 ABI,
+// either "INTEGER" or "SSE", or nil if s is bigger than 16 bytes and goes in memory.
+func ClassifySysV(s Struct, structs map[string]Struct) []string {
+	if s.Size > 16 {
+		return nil
+	}
+	classes := make([]string, (s.Size+7)/8)
+	for i := range classes {
+		classes[i] = "SSE"
+	}
+	// an eightbyte only goes in a vector register if everything in it is a float
+	for _, f := range Scalars(s.Name, structs) {
+		if !IsFloatType(f.Type) {
+			classes[f.Offset/8] = "INTEGER"
+		}
+	}
+	return classes
+}
+
+// HFA reports whether s is a homogeneous floating point aggregate under AAPCS64, one to four floats of the same type,
+// which are passed in consecutive vector registers. It returns the float type and the members.
+func HFA(s Struct, structs map[string]Struct) (string, []Field, bool) {
+	members := Scalars(s.Name, structs)
+	if len(members) > 4 {
+		return "", nil, false
+	}
+	for _, m := range members {
+		if !IsFloatType(m.Type) || m.Type != members[0].Type {
+			return "", nil, false
+		}
+	}
+	return members[0].Type, members, true
+}
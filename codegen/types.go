@@ -0,0 +1,296 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/westsi/dormouse/ast"
+)
+
+// Signature describes how a function is called, for checking call sites.
+type Signature struct {
+	ReturnType string
+	Params     []string
+	Variadic   bool
+	// declared with @extern and defined outside of Dormouse, e.g. in libc
+	Extern bool
+}
+
+// CollectSignatures finds every function defined or declared at the top level of a program.
+// The names of the builtins are taken by the runtime, so functions with those names are rejected.
+func CollectSignatures(program *ast.Program) (map[string]Signature, error) {
+	sigs := make(map[string]Signature)
+	for _, stmt := range program.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.FunctionDefinition:
+			if IsBuiltin(stmt.Name.Value) {
+				return nil, fmt.Errorf("%v: function %s has the name of a builtin", stmt.Token.Pos, stmt.Name.Value)
+			}
+			sigs[stmt.Name.Value] = Signature{ReturnType: stmt.ReturnType.Value, Params: paramTypes(stmt.Parameters)}
+		case *ast.ExternDeclaration:
+			if IsBuiltin(stmt.Name.Value) {
+				return nil, fmt.Errorf("%v: extern %s has the name of a builtin", stmt.Token.Pos, stmt.Name.Value)
+			}
+			sigs[stmt.Name.Value] = Signature{ReturnType: stmt.ReturnType.Value, Params: paramTypes(stmt.Parameters), Variadic: stmt.Variadic, Extern: true}
+		}
+	}
+	return sigs, nil
+}
+
+func paramTypes(params []*ast.Parameter) []string {
+	types := []string{}
+	for _, p := range params {
+		types = append(types, p.Type.Value)
+	}
+	return types
+}
+
+// CheckCall checks the number and types of the arguments of c against sig, returning an error describing the first mismatch.
+// Arguments whose type cannot be inferred are not checked.
+func CheckCall(c *ast.CallExpression, sig Signature, s Scope) error {
+	name := c.Function.Value
+	if len(c.Arguments) < len(sig.Params) || (!sig.Variadic && len(c.Arguments) > len(sig.Params)) {
+		return fmt.Errorf("%s takes %d arguments, got %d", name, len(sig.Params), len(c.Arguments))
+	}
+	for i, param := range sig.Params {
+		if t := TypeOf(c.Arguments[i], s); t != "" && t != param && !ArrayPassable(t, param) {
+			return fmt.Errorf("argument %d of %s should be %s, got %s", i+1, name, param, t)
+		}
+	}
+	return nil
+}
+
+// CheckAssign checks that the value of expr can be stored as type to, as in a definition, assignment or return.
+// Floats and doubles are converted into each other, anything else has to be converted with `as`.
+func CheckAssign(expr ast.Expression, to string, s Scope) error {
+	t := TypeOf(expr, s)
+	if t == "" || t == to || IsFloatType(t) && IsFloatType(to) || ArrayPassable(t, to) {
+		return nil
+	}
+	return fmt.Errorf("cannot use %s as %s, use `as` to convert it", t, to)
+}
+
+// Scope resolves the types of names visible to the code being generated.
+// VarType and FuncType return "" when the name is not known.
+type Scope interface {
+	VarType(name string) string
+	FuncType(name string) string
+	StructType(name string) (Struct, bool)
+}
+
+var IntegerTypes = []string{"int"}
+var FloatTypes = []string{"float", "double"}
+
+func IsIntegerType(t string) bool {
+	for _, it := range IntegerTypes {
+		if it == t {
+			return true
+		}
+	}
+	return false
+}
+
+func IsFloatType(t string) bool {
+	for _, ft := range FloatTypes {
+		if ft == t {
+			return true
+		}
+	}
+	return false
+}
+
+// IsScalarType reports whether t is held in a single register and can be converted with `as`.
+func IsScalarType(t string) bool {
+	return t == "bool" || IsIntegerType(t) || IsFloatType(t)
+}
+
+// IsArrayType reports whether t is an array, either fixed size like int[10] or passed by pointer like int[].
+func IsArrayType(t string) bool {
+	return strings.HasSuffix(t, "]") && strings.Contains(t, "[")
+}
+
+// IsPointerType reports whether t is a pointer, like int*.
+func IsPointerType(t string) bool {
+	return strings.HasSuffix(t, "*")
+}
+
+// PointeeType returns the type the pointer type t points to.
+func PointeeType(t string) string {
+	return strings.TrimSuffix(t, "*")
+}
+
+// PointeeSize returns how far adding one to a pointer of type t moves it.
+// Structs are as big as their layout and anything else takes 8 bytes, as array elements do.
+func PointeeSize(t string, s Scope) int {
+	if st, ok := s.StructType(PointeeType(t)); ok {
+		return st.Size
+	}
+	return 8
+}
+
+// ElementType returns the type of the elements of the array type t.
+func ElementType(t string) string {
+	return t[:strings.LastIndex(t, "[")]
+}
+
+// ArrayLen returns the number of elements in the array type t, or -1 if the length is not part of the type.
+func ArrayLen(t string) int {
+	n, err := strconv.Atoi(t[strings.LastIndex(t, "[")+1 : len(t)-1])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// IsArrayRef reports whether t is an array without a length, which is held as a pointer to its first element.
+func IsArrayRef(t string) bool {
+	return IsArrayType(t) && ArrayLen(t) < 0
+}
+
+// ArrayPassable reports whether an array of type from can be passed where an array of type to is expected.
+// Arrays are passed by pointer, so any length can be passed as an array without one.
+func ArrayPassable(from, to string) bool {
+	return IsArrayType(from) && IsArrayRef(to) && ElementType(from) == ElementType(to)
+}
+
+// CheckArrayType checks that the elements of the array type t fit in a register, as arrays are indexed 8 bytes at a
+// time. Structs and arrays can be kept in arrays through pointers to them instead.
+func CheckArrayType(t string) error {
+	if e := ElementType(t); !IsScalarType(e) && !IsPointerType(e) && e != "string" {
+		return fmt.Errorf("arrays cannot hold values of type %s, only scalars, strings and pointers", e)
+	}
+	return nil
+}
+
+// CastAllowed reports whether a value of type from can be cast to type to.
+// Casts from an unknown type are allowed as they cannot be checked.
+func CastAllowed(from, to string) bool {
+	if from == "" || from == to {
+		return true
+	}
+	// pointers can be turned into addresses and back, and reinterpreted as other pointers
+	if IsPointerType(from) && (IsPointerType(to) || IsIntegerType(to)) || IsIntegerType(from) && IsPointerType(to) {
+		return true
+	}
+	return IsScalarType(from) && IsScalarType(to)
+}
+
+// TypeOf infers the type of an expression, returning "" if it cannot be determined.
+func TypeOf(node ast.Expression, s Scope) string {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return "int"
+	case *ast.FloatLiteral:
+		return "double"
+	case *ast.StringLiteral:
+		return "string"
+	case *ast.Boolean:
+		return "bool"
+	case *ast.Identifier:
+		return s.VarType(node.Value)
+	case *ast.CallExpression:
+		return s.FuncType(node.Function.Value)
+	case *ast.CastExpression:
+		return node.Type.Value
+	case *ast.IndexExpression:
+		if t := TypeOf(node.Left, s); IsArrayType(t) {
+			return ElementType(t)
+		}
+	case *ast.FieldExpression:
+		t := TypeOf(node.Left, s)
+		// fields are reached through pointers to structs as well
+		if IsPointerType(t) {
+			t = PointeeType(t)
+		}
+		if st, ok := s.StructType(t); ok {
+			if f, ok := st.Field(node.Field.Value); ok {
+				return f.Type
+			}
+		}
+	case *ast.PrefixExpression:
+		t := TypeOf(node.Right, s)
+		switch {
+		case node.Operator == "!":
+			return "bool"
+		case node.Operator == "&" && t != "":
+			return t + "*"
+		case node.Operator == "*" && IsPointerType(t):
+			return PointeeType(t)
+		case node.Operator == "&", node.Operator == "*":
+			return ""
+		}
+		return t
+	case *ast.InfixExpression:
+		if IsComparison(node.Operator) || node.Operator == "&&" || node.Operator == "||" {
+			return "bool"
+		}
+		left, right := TypeOf(node.Left, s), TypeOf(node.Right, s)
+		if IsPointerType(left) && IsPointerType(right) && node.Operator == "-" {
+			return "int"
+		}
+		if IsPointerType(right) && !IsPointerType(left) {
+			return right
+		}
+		// float operands are promoted to double if the other side is a double, as in C
+		if IsFloatType(left) && IsFloatType(right) && left != right {
+			return "double"
+		}
+		if left == "" {
+			return right
+		}
+		return left
+	}
+	return ""
+}
+
+// PointerArithmetic rewrites adding an int to a pointer, or subtracting two pointers of the same type, into integer
+// arithmetic on their addresses scaled by PointeeSize. It returns false if node is not pointer arithmetic.
+func PointerArithmetic(node *ast.InfixExpression, s Scope) (ast.Expression, bool) {
+	left, right := TypeOf(node.Left, s), TypeOf(node.Right, s)
+	if node.Operator != "+" && node.Operator != "-" || !IsPointerType(left) && !IsPointerType(right) {
+		return nil, false
+	}
+	tok := node.Token
+	address := func(e ast.Expression) ast.Expression {
+		return &ast.CastExpression{Token: tok, Left: e, Type: &ast.Type{Token: tok, Value: "int"}}
+	}
+	scaled := func(e ast.Expression, size int) ast.Expression {
+		return &ast.InfixExpression{Token: tok, Left: e, Operator: "*", Right: &ast.IntegerLiteral{Token: tok, Value: int64(size)}}
+	}
+	switch {
+	case left == right && node.Operator == "-":
+		diff := &ast.InfixExpression{Token: tok, Left: address(node.Left), Operator: "-", Right: address(node.Right)}
+		return &ast.InfixExpression{Token: tok, Left: diff, Operator: "/", Right: &ast.IntegerLiteral{Token: tok, Value: int64(PointeeSize(left, s))}}, true
+	case IsPointerType(left) && IsIntegerType(right):
+		sum := &ast.InfixExpression{Token: tok, Left: address(node.Left), Operator: node.Operator, Right: scaled(node.Right, PointeeSize(left, s))}
+		return &ast.CastExpression{Token: tok, Left: sum, Type: &ast.Type{Token: tok, Value: left}}, true
+	case IsIntegerType(left) && IsPointerType(right) && node.Operator == "+":
+		sum := &ast.InfixExpression{Token: tok, Left: scaled(node.Left, PointeeSize(right, s)), Operator: "+", Right: address(node.Right)}
+		return &ast.CastExpression{Token: tok, Left: sum, Type: &ast.Type{Token: tok, Value: right}}, true
+	}
+	return nil, false
+}
+
+// OperandType returns the type arithmetic or a comparison between left and right is done in.
+// Only floats of different precisions can be mixed, anything else needs an explicit cast.
+func OperandType(left, right string) (string, error) {
+	switch {
+	case left == right || right == "":
+		return left, nil
+	case left == "":
+		return right, nil
+	case IsFloatType(left) && IsFloatType(right):
+		return "double", nil
+	}
+	return "", fmt.Errorf("mismatched types %s and %s, use `as` to convert one of them", left, right)
+}
+
+// IsComparison reports whether op compares its operands, giving a bool.
+func IsComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", ">", "<=", ">=":
+		return true
+	}
+	return false
+}
@@ -2,7 +2,10 @@ package x86_64_as
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/westsi/dormouse/ast"
@@ -22,6 +25,19 @@ type X64Generator struct {
 	VirtualRegisters map[StorageLoc]string
 	LabelCounter     int
 	Gdefs            map[string]string
+	Loops            *util.Stack[codegen.LoopContext]
+	Functions        map[string]codegen.Signature
+	Structs          map[string]codegen.Struct
+	Globals          map[string]string // types of the variables defined outside of functions in any file
+	ReturnType       string
+	ResultPtr        int                         // offset of where a struct too big for registers is returned to, 0 if there is none
+	StructTemps      map[*ast.CallExpression]int // offsets of the space set aside for the results of calls returning structs
+	TempDepth        int                         // bytes pushed on top of the frame while working out an expression
+	ReturnLabel      string                      // the epilogue of the function being generated, which every return jumps to
+	Saved            map[StorageLoc]bool         // callee saved registers used by the function being generated
+	BoundsChecks     bool
+	data             strings.Builder // read only data, placed after the code
+	vars             strings.Builder // globals defined in this file, each in .data or .bss
 }
 
 type StorageLoc int
@@ -49,9 +65,19 @@ var Sls = []StorageLoc{RAX, RCX, RDX, R8, R9, R10, R11, R12, R13, R14, R15}
 
 var StorageLocs = []string{"%rax", "%rcx", "%rdx", "%rdi", "%rsi", "%r8", "%r9", "%r10", "%r11", "%r12", "%r13", "%r14", "%r15"}
 
+var StorageLocs32 = []string{"%eax", "%ecx", "%edx", "%edi", "%esi", "%r8d", "%r9d", "%r10d", "%r11d", "%r12d", "%r13d", "%r14d", "%r15d"}
+
+var StorageLocs8 = []string{"%al", "%cl", "%dl", "%dil", "%sil", "%r8b", "%r9b", "%r10b", "%r11b", "%r12b", "%r13b", "%r14b", "%r15b"}
+
 var FNCallRegs = []StorageLoc{RDI, RSI, RDX, RCX, R8, R9}
 
-func New(fpath string, ast *ast.Program, defs map[string]string, lc int) *X64Generator {
+// CalleeSaved are the registers a function has to give back as it found them.
+var CalleeSaved = []StorageLoc{R12, R13, R14, R15}
+
+// float arguments and return values are passed in these, separately from the general purpose ones
+var FloatCallRegs = []string{"%xmm0", "%xmm1", "%xmm2", "%xmm3", "%xmm4", "%xmm5", "%xmm6", "%xmm7"}
+
+func New(fpath string, ast *ast.Program, defs map[string]string, sigs map[string]codegen.Signature, structs map[string]codegen.Struct, globals map[string]string, lc int, boundsChecks bool) *X64Generator {
 	generator := &X64Generator{
 		fpath:            fpath,
 		out:              strings.Builder{},
@@ -60,6 +86,18 @@ func New(fpath string, ast *ast.Program, defs map[string]string, lc int) *X64Gen
 		VirtualRegisters: map[StorageLoc]string{},
 		LabelCounter:     lc,
 		Gdefs:            defs,
+		Loops:            util.NewStack[codegen.LoopContext](),
+		Functions:        map[string]codegen.Signature{},
+		Structs:          structs,
+		Globals:          globals,
+		BoundsChecks:     boundsChecks,
+		Saved:            map[StorageLoc]bool{},
+	}
+	for k, v := range codegen.Builtins {
+		generator.Functions[k] = v
+	}
+	for k, v := range sigs {
+		generator.Functions[k] = v
 	}
 	os.MkdirAll("out/x86_64", os.ModePerm)
 	os.MkdirAll("out/x86_64/asm", os.ModePerm)
@@ -76,6 +114,19 @@ func (g *X64Generator) Write() {
 	if err != nil {
 		panic(err)
 	}
+	if g.data.Len() > 0 {
+		// switch back to .text afterwards as the next file's code is appended to this one
+		_, err = f.WriteString(".section .rodata\n" + g.data.String() + ".text\n")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if g.vars.Len() > 0 {
+		_, err = f.WriteString(g.vars.String() + ".text\n")
+		if err != nil {
+			panic(err)
+		}
+	}
 }
 
 func (g *X64Generator) e(tok lex.LexedTok, err string) {
@@ -88,13 +139,12 @@ func (g *X64Generator) GetVarStackOffset(name string) int {
 	defer tracer.Untrace("GetVarStackOffset")
 	// get offset from bottom of stack in terms of indices and multiply by sizes to get bits
 	sizeBelow := 0
-	for _, v := range g.VirtualStack.Elements {
-		switch v.Type {
-		case "int":
-			sizeBelow += 8
-		}
-
-		if v.Name == name {
+	// search from the top of the stack so that inner scopes shadow outer ones
+	for i := len(g.VirtualStack.Elements) - 1; i >= 0; i-- {
+		if g.VirtualStack.Elements[i].Name == name {
+			for _, v := range g.VirtualStack.Elements[:i+1] {
+				sizeBelow += g.SizeOf(v.Type)
+			}
 			return sizeBelow
 		}
 	}
@@ -112,6 +162,54 @@ func (g *X64Generator) GetVarStackOffset(name string) int {
 	return -1
 }
 
+// SizeOf returns the number of bytes a variable of type t occupies on the stack.
+func (g *X64Generator) SizeOf(t string) int {
+	switch {
+	case codegen.IsScalarType(t), codegen.IsArrayRef(t), codegen.IsPointerType(t), t == "string":
+		return 8
+	case codegen.IsArrayType(t):
+		return codegen.ArrayLen(t) * g.SizeOf(codegen.ElementType(t))
+	}
+	if st, ok := g.Structs[t]; ok {
+		// rounded up so the stack stays 8 byte aligned
+		return (st.Size + 7) / 8 * 8
+	}
+	return 0
+}
+
+// ByAddress reports whether values of type t are too big for a register and are worked with through their address.
+func (g *X64Generator) ByAddress(t string) bool {
+	_, isStruct := g.Structs[t]
+	return isStruct || codegen.IsArrayType(t) && !codegen.IsArrayRef(t)
+}
+
+// VarType implements codegen.Scope.
+func (g *X64Generator) VarType(name string) string {
+	for i := len(g.VirtualStack.Elements) - 1; i >= 0; i-- {
+		if g.VirtualStack.Elements[i].Name == name {
+			return g.VirtualStack.Elements[i].Type
+		}
+	}
+	if t, ok := g.Globals[name]; ok {
+		return t
+	}
+	if _, ok := g.Gdefs[name]; ok {
+		return "int"
+	}
+	return ""
+}
+
+// FuncType implements codegen.Scope.
+func (g *X64Generator) FuncType(name string) string {
+	return g.Functions[name].ReturnType
+}
+
+// StructType implements codegen.Scope.
+func (g *X64Generator) StructType(name string) (codegen.Struct, bool) {
+	st, ok := g.Structs[name]
+	return st, ok
+}
+
 func (g *X64Generator) GetVTabVar(name string) codegen.VTabVar {
 	tracer.Trace("GetVTabVar")
 	defer tracer.Untrace("GetVTabVar")
@@ -123,6 +221,20 @@ func (g *X64Generator) GetVTabVar(name string) codegen.VTabVar {
 	return codegen.VTabVar{}
 }
 
+// GetFreeReg claims the first unused register for owner, which is either a variable name or "TEMP".
+func (g *X64Generator) GetFreeReg(owner string) StorageLoc {
+	for _, v := range Sls {
+		if _, ok := g.VirtualRegisters[v]; !ok {
+			g.VirtualRegisters[v] = owner
+			g.Saved[v] = slices.Contains(CalleeSaved, v)
+			return v
+		}
+	}
+	fmt.Println("Out of registers!")
+	os.Exit(1)
+	return NULLSTORAGE
+}
+
 func (g *X64Generator) GetVarStorageLoc(name string) (StorageLoc, error) {
 	tracer.Trace("GetVarStorageLoc")
 	defer tracer.Untrace("GetVarStorageLoc")
@@ -142,10 +254,27 @@ func (g *X64Generator) GetVarStorageLoc(name string) (StorageLoc, error) {
 func (g *X64Generator) Generate() int {
 	tracer.Trace("Generate")
 	defer tracer.Untrace("Generate")
+	// collect signatures first so calls to functions defined further down can be checked
+	sigs, err := codegen.CollectSignatures(&g.AST)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for k, v := range sigs {
+		g.Functions[k] = v
+	}
 	for _, stmt := range g.AST.Statements {
 		switch stmt := stmt.(type) {
 		case *ast.FunctionDefinition:
+			if stmt.Name.Value == "main" {
+				// every program has one main, so the runtime goes with it
+				g.out.WriteString(runtime)
+			}
 			g.GenerateFunction(stmt)
+		case *ast.VarStatement:
+			g.GenerateGlobal(stmt)
+		case *ast.ExternDeclaration:
+			g.out.WriteString(".extern " + stmt.Name.Value + "\n")
 		}
 	}
 	return g.LabelCounter
@@ -163,13 +292,28 @@ func (g *X64Generator) GenerateExpression(node ast.Expression) StorageLoc {
 		// g.out.WriteString("movq $" + fmt.Sprintf("%d", node.Value) + ", %rax\n")
 		// return RAX
 		return g.GenerateIntegerLiteral(node)
+	case *ast.FloatLiteral:
+		return g.GenerateFloatLiteral(node, "double")
+	case *ast.StringLiteral:
+		return g.GenerateStringLiteral(node)
+	case *ast.Boolean:
+		return g.GenerateBoolean(node)
+	case *ast.PrefixExpression:
+		return g.GeneratePrefix(node)
+	case *ast.CastExpression:
+		return g.GenerateCast(node)
 	case *ast.IfExpression:
 		g.GenerateIf(node)
 	case *ast.WhileExpression:
 		g.GenerateWhileLoop(node)
+	case *ast.ForExpression:
+		g.GenerateForLoop(node)
 	case *ast.CallExpression:
-		g.GenerateCall(node)
-		return RAX
+		return g.GenerateCall(node)
+	case *ast.IndexExpression:
+		return g.GenerateIndex(node)
+	case *ast.FieldExpression:
+		return g.GenerateField(node)
 	}
 	return NULLSTORAGE
 }
@@ -177,6 +321,7 @@ func (g *X64Generator) GenerateExpression(node ast.Expression) StorageLoc {
 func (g *X64Generator) GenerateBlock(b *ast.BlockStatement) {
 	tracer.Trace("GenerateBlock")
 	defer tracer.Untrace("GenerateBlock")
+	scope := g.EnterScope()
 	for _, stmt := range b.Statements {
 		switch stmt := stmt.(type) {
 		case *ast.FunctionDefinition:
@@ -185,12 +330,70 @@ func (g *X64Generator) GenerateBlock(b *ast.BlockStatement) {
 			g.GenerateVarDef(stmt)
 		case *ast.VarReassignmentStatement:
 			g.GenerateVarReassignment(stmt)
+		case *ast.AssignmentStatement:
+			g.GenerateAssignment(stmt)
 		case *ast.ReturnStatement:
 			g.GenerateReturn(stmt)
+		case *ast.BreakStatement:
+			g.GenerateBreak(stmt)
+		case *ast.ContinueStatement:
+			g.GenerateContinue(stmt)
 		case *ast.ExpressionStatement:
 			g.GenerateExpression(stmt.Expression)
 		}
+		// every statement stores its result, so nothing needs to stay in a register
+		g.VirtualRegisters = map[StorageLoc]string{}
 	}
+	g.ExitScope(scope)
+}
+
+// EnterScope returns the current depth of the virtual stack so that variables
+// declared after it can be dropped by ExitScope.
+func (g *X64Generator) EnterScope() int {
+	return g.VirtualStack.Size()
+}
+
+// StackDepth returns the number of bytes below %rbp taken up by the locals in scope.
+func (g *X64Generator) StackDepth() int {
+	depth := 0
+	for _, v := range g.VirtualStack.Elements {
+		depth += g.SizeOf(v.Type)
+	}
+	return depth
+}
+
+// ExitScope pops every variable declared since depth from the virtual stack, so later ones can reuse their space.
+func (g *X64Generator) ExitScope(depth int) {
+	tracer.Trace("ExitScope")
+	defer tracer.Untrace("ExitScope")
+	for g.VirtualStack.Size() > depth {
+		g.VirtualStack.Pop()
+	}
+}
+
+// Local returns the memory operand of the local or parameter on top of the virtual stack.
+func (g *X64Generator) Local() string {
+	return fmt.Sprintf("-%d(%%rbp)", g.StackDepth())
+}
+
+// FrameSize works out how many bytes below %rbp f needs for its parameters, the variables it defines and the results
+// of calls returning structs. Variables of scopes that have ended share space, so this is more than enough.
+func (g *X64Generator) FrameSize(f *ast.FunctionDefinition) int {
+	size := 8 // where a struct too big for registers is returned to
+	for _, param := range f.Parameters {
+		size += g.SizeOf(param.Type.Value)
+	}
+	ast.Inspect(f.Body, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.VarStatement:
+			size += g.SizeOf(n.Type.Value)
+		case *ast.CallExpression:
+			if t := g.FuncType(n.Function.Value); g.Structs[t].Name != "" {
+				size += g.SizeOf(t)
+			}
+		}
+	})
+	return size
 }
 
 func (g *X64Generator) GenerateFunction(f *ast.FunctionDefinition) {
@@ -198,8 +401,84 @@ func (g *X64Generator) GenerateFunction(f *ast.FunctionDefinition) {
 	defer tracer.Untrace("GenerateFunction")
 	// save old virtual stack but assume all registers other than rsp, rbp are clobbered
 	oldVirtStack := g.VirtualStack
+	oldReturnType := g.ReturnType
+	oldResultPtr, oldStructTemps := g.ResultPtr, g.StructTemps
+	oldReturnLabel, oldSaved := g.ReturnLabel, g.Saved
 	g.VirtualStack = util.NewStack[codegen.VTabVar]()
 	g.VirtualRegisters = map[StorageLoc]string{}
+	g.ReturnType = f.ReturnType.Value
+	g.ReturnLabel = g.NewLabel()
+	g.Saved = map[StorageLoc]bool{}
+	frame := g.FrameSize(f)
+
+	// the body is generated first, as the prologue saves the callee saved registers it turns out to use
+	before := g.out.String()
+	g.out.Reset()
+	// move params to stack and set virtual stack
+	intArgs, floatArgs := 0, 0
+	g.ResultPtr = 0
+	if st, ok := g.Structs[g.ReturnType]; ok && codegen.ClassifySysV(st, g.Structs) == nil {
+		// the caller passes where to write a big struct in %rdi
+		g.VirtualStack.Push(codegen.VTabVar{Type: "int"})
+		g.out.WriteString("movq %rdi, " + g.Local() + "\n")
+		g.ResultPtr = g.StackDepth()
+		intArgs++
+	}
+	stackArgs := 16 // arguments passed on the stack start above the return address and saved %rbp
+	for _, param := range f.Parameters {
+		if codegen.IsArrayType(param.Type.Value) {
+			if err := codegen.CheckArrayType(param.Type.Value); err != nil {
+				g.e(param.Token, err.Error())
+			}
+		}
+		g.VirtualStack.Push(codegen.VTabVar{Name: param.Name.Value, Type: param.Type.Value})
+		st, isStruct := g.Structs[param.Type.Value]
+		switch {
+		case isStruct:
+			regs := g.StructRegs(st, intArgs, floatArgs)
+			if regs == nil {
+				g.CopyMemory("%rbp", stackArgs, "%rbp", -g.StackDepth(), st.Size)
+				stackArgs += g.SizeOf(param.Type.Value)
+			}
+			for i, reg := range regs {
+				g.out.WriteString("movq " + reg + ", " + fmt.Sprintf("%d", i*8-g.StackDepth()) + "(%rbp)\n")
+				if strings.HasPrefix(reg, "%xmm") {
+					floatArgs++
+				} else {
+					intArgs++
+				}
+			}
+		case codegen.IsFloatType(param.Type.Value) && floatArgs == len(FloatCallRegs),
+			!codegen.IsFloatType(param.Type.Value) && intArgs == len(FNCallRegs):
+			// the rest of the arguments of this kind were passed on the stack
+			g.out.WriteString("movq " + fmt.Sprintf("%d", stackArgs) + "(%rbp), %rax\n")
+			g.out.WriteString("movq %rax, " + g.Local() + "\n")
+			stackArgs += 8
+		case param.Type.Value == "float":
+			g.out.WriteString("movss " + FloatCallRegs[floatArgs] + ", " + g.Local() + "\n")
+			floatArgs++
+		case param.Type.Value == "double":
+			g.out.WriteString("movsd " + FloatCallRegs[floatArgs] + ", " + g.Local() + "\n")
+			floatArgs++
+		default:
+			g.out.WriteString("movq " + StorageLocs[FNCallRegs[intArgs]] + ", " + g.Local() + "\n")
+			intArgs++
+		}
+	}
+	// set aside space for the results of calls returning structs, so they have an address
+	g.StructTemps = map[*ast.CallExpression]int{}
+	ast.Inspect(f.Body, func(n ast.Node) {
+		if c, ok := n.(*ast.CallExpression); ok {
+			if t := g.FuncType(c.Function.Value); g.Structs[t].Name != "" {
+				g.VirtualStack.Push(codegen.VTabVar{Type: t})
+				g.StructTemps[c] = g.StackDepth()
+			}
+		}
+	})
+	g.GenerateBlock(f.Body)
+	body := g.out.String()
+	g.out.Reset()
+	g.out.WriteString(before)
 
 	if f.Name.Value == "main" {
 		g.out.WriteString(".text\n.globl main\n")
@@ -210,33 +489,160 @@ func (g *X64Generator) GenerateFunction(f *ast.FunctionDefinition) {
 	// setup local stack for function
 	g.out.WriteString("pushq %rbp\n")      // save old base pointer to stack
 	g.out.WriteString("movq %rsp, %rbp\n") // use stack top pointer as base pointer for function
-	// move params to stack and set virtual stack
-	for i, param := range f.Parameters {
-		g.out.WriteString("pushq " + StorageLocs[FNCallRegs[i]] + "\n")
-		g.VirtualStack.Push(codegen.VTabVar{Name: param.Name.Value, Type: param.Type.Value})
+	// the callee saved registers go below the locals, and the frame is rounded up so %rsp stays 16 byte aligned
+	var saved []StorageLoc
+	for _, reg := range CalleeSaved {
+		if g.Saved[reg] {
+			saved = append(saved, reg)
+		}
 	}
-	g.GenerateBlock(f.Body)
+	if size := (frame + 8*len(saved) + 15) / 16 * 16; size > 0 {
+		g.out.WriteString("subq $" + fmt.Sprintf("%d", size) + ", %rsp\n")
+	}
+	for i, reg := range saved {
+		g.out.WriteString("movq " + StorageLocs[reg] + ", " + fmt.Sprintf("-%d", frame+8*(i+1)) + "(%rbp)\n")
+	}
+	g.out.WriteString(body)
+	// every return jumps here, and functions returning nothing fall through to it
+	g.out.WriteString(g.ReturnLabel + ":\n")
+	for i, reg := range saved {
+		g.out.WriteString("movq " + fmt.Sprintf("-%d", frame+8*(i+1)) + "(%rbp), " + StorageLocs[reg] + "\n")
+	}
+	g.out.WriteString("movq %rbp, %rsp\n")
+	g.out.WriteString("popq %rbp\n")
+	g.out.WriteString("ret\n")
 	// restore old virtual stack
 	g.VirtualStack = oldVirtStack
+	g.ReturnType = oldReturnType
+	g.ResultPtr, g.StructTemps = oldResultPtr, oldStructTemps
+	g.ReturnLabel, g.Saved = oldReturnLabel, oldSaved
 	g.VirtualRegisters = map[StorageLoc]string{}
 }
 
 func (g *X64Generator) GenerateVarDef(v *ast.VarStatement) {
 	tracer.Trace("GenerateVarDef")
 	defer tracer.Untrace("GenerateVarDef")
-	sloc := g.GenerateExpression(v.Value.(*ast.ExpressionStatement).Expression)
+	if codegen.IsArrayType(v.Type.Value) && !codegen.IsArrayRef(v.Type.Value) {
+		g.GenerateArrayDef(v)
+		return
+	}
+	if st, ok := g.Structs[v.Type.Value]; ok {
+		g.GenerateStructDef(v, st)
+		return
+	}
+	if v.Value == nil && v.Type.Value == "string" {
+		// strings start out empty rather than as a null pointer, so they can always be used
+		v = &ast.VarStatement{Token: v.Token, Name: v.Name, Type: v.Type, Value: &ast.ExpressionStatement{Token: v.Token, Expression: &ast.StringLiteral{Token: v.Token}}}
+	}
+	if v.Value == nil {
+		g.VirtualStack.Push(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value})
+		g.out.WriteString("movq $0, " + g.Local() + "\n")
+		return
+	}
+	if err := codegen.CheckAssign(v.Value.(*ast.ExpressionStatement).Expression, v.Type.Value, g); err != nil {
+		g.e(v.Token, err.Error())
+	}
+	sloc := g.GenerateConverted(v.Value.(*ast.ExpressionStatement).Expression, v.Type.Value)
 	if sloc == NULLSTORAGE {
 		fmt.Println("\033[31mPROBLEM PANICCCCCCC\033[0m")
 	}
-	// TODO: make this work with more than a simple int literal e.g. infix ops
 	g.VirtualStack.Push(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value})
+	g.out.WriteString("movq " + StorageLocs[sloc] + ", " + g.Local() + "\n")
+}
 
-	switch v.Type.Value {
-	case "int":
-		// TODO: this only works with infix ops in the def because of gcc optimizations afaik.
-		// g.out.WriteString("pushq $" + v.Value.String() + "\n") // load value into stack
-		g.out.WriteString("pushq " + StorageLocs[sloc] + "\n")
+// GenerateArrayDef reserves space for a fixed size array and zeroes it.
+func (g *X64Generator) GenerateArrayDef(v *ast.VarStatement) {
+	tracer.Trace("GenerateArrayDef")
+	defer tracer.Untrace("GenerateArrayDef")
+	if v.Value != nil {
+		g.e(v.Token, "arrays cannot be initialised with a value: "+v.Name.Value)
+	}
+	if err := codegen.CheckArrayType(v.Type.Value); err != nil {
+		g.e(v.Token, err.Error())
+	}
+	if codegen.ArrayLen(v.Type.Value) <= 0 {
+		g.e(v.Token, "array length must be positive: "+v.Type.Value)
+	}
+	g.VirtualStack.Push(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value})
+	// nothing is held in registers between statements, so rep stosq can use them freely
+	g.out.WriteString("leaq " + g.Local() + ", %rdi\n")
+	g.out.WriteString("movq $" + fmt.Sprintf("%d", g.SizeOf(v.Type.Value)/8) + ", %rcx\n")
+	g.out.WriteString("xorq %rax, %rax\n")
+	g.out.WriteString("rep stosq\n")
+}
+
+// GenerateStructDef reserves space for a struct and copies its value in, or zeroes it if it has none.
+func (g *X64Generator) GenerateStructDef(v *ast.VarStatement, st codegen.Struct) {
+	tracer.Trace("GenerateStructDef")
+	defer tracer.Untrace("GenerateStructDef")
+	size := g.SizeOf(v.Type.Value)
+	if v.Value == nil {
+		g.VirtualStack.Push(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value})
+		// nothing is held in registers between statements, so rep stosq can use them freely
+		g.out.WriteString("leaq " + g.Local() + ", %rdi\n")
+		g.out.WriteString("movq $" + fmt.Sprintf("%d", size/8) + ", %rcx\n")
+		g.out.WriteString("xorq %rax, %rax\n")
+		g.out.WriteString("rep stosq\n")
+		return
+	}
+	value := v.Value.(*ast.ExpressionStatement).Expression
+	if t := codegen.TypeOf(value, g); t != v.Type.Value {
+		g.e(v.Token, "cannot use "+t+" as "+v.Type.Value)
+	}
+	src := g.GenerateExpression(value)
+	g.VirtualStack.Push(codegen.VTabVar{Name: v.Name.Value, Type: v.Type.Value})
+	g.CopyMemory(StorageLocs[src], 0, "%rbp", -g.StackDepth(), st.Size)
+}
+
+// CopyMemory copies size bytes from the address in register src to the address in register dest, at the given offsets.
+func (g *X64Generator) CopyMemory(src string, srcOff int, dest string, destOff int, size int) {
+	tracer.Trace("CopyMemory")
+	defer tracer.Untrace("CopyMemory")
+	tmp := g.GetFreeReg("TEMP")
+	for done := 0; done < size; {
+		// copy in the biggest pieces that fit
+		switch {
+		case size-done >= 8:
+			g.out.WriteString(fmt.Sprintf("movq %d(%s), %s\n", srcOff+done, src, StorageLocs[tmp]))
+			g.out.WriteString(fmt.Sprintf("movq %s, %d(%s)\n", StorageLocs[tmp], destOff+done, dest))
+			done += 8
+		case size-done >= 4:
+			g.out.WriteString(fmt.Sprintf("movl %d(%s), %s\n", srcOff+done, src, StorageLocs32[tmp]))
+			g.out.WriteString(fmt.Sprintf("movl %s, %d(%s)\n", StorageLocs32[tmp], destOff+done, dest))
+			done += 4
+		default:
+			g.out.WriteString(fmt.Sprintf("movb %d(%s), %s\n", srcOff+done, src, StorageLocs8[tmp]))
+			g.out.WriteString(fmt.Sprintf("movb %s, %d(%s)\n", StorageLocs8[tmp], destOff+done, dest))
+			done++
+		}
 	}
+	delete(g.VirtualRegisters, tmp)
+}
+
+// StructRegs returns the registers each eightbyte of st is passed in, given how many of each kind are already taken,
+// or nil if it is passed on the stack.
+func (g *X64Generator) StructRegs(st codegen.Struct, intArgs, floatArgs int) []string {
+	classes := codegen.ClassifySysV(st, g.Structs)
+	if classes == nil {
+		return nil
+	}
+	var regs []string
+	for _, class := range classes {
+		if class == "SSE" {
+			if floatArgs == len(FloatCallRegs) {
+				return nil
+			}
+			regs = append(regs, FloatCallRegs[floatArgs])
+			floatArgs++
+		} else {
+			if intArgs == len(FNCallRegs) {
+				return nil
+			}
+			regs = append(regs, StorageLocs[FNCallRegs[intArgs]])
+			intArgs++
+		}
+	}
+	return regs
 }
 
 func (g *X64Generator) GenerateIdentifier(i *ast.Identifier) StorageLoc {
@@ -247,15 +653,7 @@ func (g *X64Generator) GenerateIdentifier(i *ast.Identifier) StorageLoc {
 	if storageLoc == DEFINES {
 		for k, v := range g.Gdefs {
 			if i.Value == k {
-				var reg StorageLoc
-				for _, s := range Sls {
-					_, ok := g.VirtualRegisters[s]
-					if !ok {
-						g.VirtualRegisters[s] = i.Value
-						reg = s
-						break
-					}
-				}
+				reg := g.GetFreeReg(i.Value)
 				g.out.WriteString("movq $" + v + ", " + StorageLocs[reg] + "\n")
 				return reg
 			}
@@ -266,6 +664,8 @@ func (g *X64Generator) GenerateIdentifier(i *ast.Identifier) StorageLoc {
 		offset := g.GetVarStackOffset(i.Value)
 		if offset != -1 {
 			return g.LoadIdentFromStack(i, offset)
+		} else if _, ok := g.Globals[i.Value]; ok {
+			return g.LoadGlobal(i)
 		} else {
 			g.e(i.Token, "undefined variable: "+i.Value)
 		}
@@ -276,47 +676,498 @@ func (g *X64Generator) GenerateIdentifier(i *ast.Identifier) StorageLoc {
 func (g *X64Generator) LoadIdentFromStack(i *ast.Identifier, offset int) StorageLoc {
 	tracer.Trace("LoadIdentFromStack")
 	defer tracer.Untrace("LoadIdentFromStack")
-	var reg StorageLoc
-	for _, v := range Sls {
-		_, ok := g.VirtualRegisters[v]
-		if !ok {
-			g.VirtualRegisters[v] = i.Value
-			reg = v
-			break
-		}
+	reg := g.GetFreeReg(i.Value)
+	if g.ByAddress(g.VarType(i.Value)) {
+		// arrays and structs are used through their address
+		g.out.WriteString("leaq " + fmt.Sprintf("-%d", offset) + "(%rbp), " + StorageLocs[reg] + "\n")
+		return reg
 	}
 	g.out.WriteString("movq " + fmt.Sprintf("-%d", offset) + "(%rbp), " + StorageLocs[reg] + "\n")
 	return reg
 }
 
-func (g *X64Generator) GenerateCall(c *ast.CallExpression) {
+// LoadGlobal loads a global variable, or its address if it is used through its address.
+func (g *X64Generator) LoadGlobal(i *ast.Identifier) StorageLoc {
+	tracer.Trace("LoadGlobal")
+	defer tracer.Untrace("LoadGlobal")
+	reg := g.GetFreeReg(i.Value)
+	if g.ByAddress(g.VarType(i.Value)) {
+		g.out.WriteString("leaq " + i.Value + "(%rip), " + StorageLocs[reg] + "\n")
+		return reg
+	}
+	g.out.WriteString("movq " + i.Value + "(%rip), " + StorageLocs[reg] + "\n")
+	return reg
+}
+
+// VarOperand returns the memory operand of the variable called name, which is either on the stack or a global.
+func (g *X64Generator) VarOperand(tok lex.LexedTok, name string) string {
+	if offset := g.GetVarStackOffset(name); offset != -1 {
+		return fmt.Sprintf("-%d(%%rbp)", offset)
+	}
+	if _, ok := g.Globals[name]; !ok {
+		g.e(tok, "undefined variable: "+name)
+	}
+	return name + "(%rip)"
+}
+
+// GenerateGlobal reserves space for a variable defined outside of any function.
+// Globals with a value go in .data and the rest in .bss, which is zeroed when the program starts.
+func (g *X64Generator) GenerateGlobal(v *ast.VarStatement) {
+	tracer.Trace("GenerateGlobal")
+	defer tracer.Untrace("GenerateGlobal")
+	t := v.Type.Value
+	if g.SizeOf(t) <= 0 {
+		g.e(v.Token, "unknown type "+t+" of global "+v.Name.Value)
+	}
+	if codegen.IsArrayType(t) {
+		if err := codegen.CheckArrayType(t); err != nil {
+			g.e(v.Token, err.Error())
+		}
+	}
+	if t == "string" {
+		// strings point at their literal, which starts out empty if there is none
+		text, err := codegen.GlobalString(v)
+		if err != nil {
+			g.e(v.Token, err.Error())
+		}
+		label := g.StringData(text)
+		g.vars.WriteString(".data\n.p2align 3\n" + v.Name.Value + ":\n")
+		g.vars.WriteString(".quad " + label + "\n")
+		return
+	}
+	if v.Value == nil {
+		g.vars.WriteString(".bss\n.p2align 3\n" + v.Name.Value + ":\n")
+		g.vars.WriteString(".zero " + fmt.Sprintf("%d", g.SizeOf(t)) + "\n")
+		return
+	}
+	if g.ByAddress(t) {
+		g.e(v.Token, "global "+v.Name.Value+" of type "+t+" cannot be initialised with a value")
+	}
+	bits, err := codegen.GlobalValue(v)
+	if err != nil {
+		g.e(v.Token, err.Error())
+	}
+	g.vars.WriteString(".data\n.p2align 3\n" + v.Name.Value + ":\n")
+	g.vars.WriteString(".quad " + fmt.Sprintf("%d", bits) + "\n")
+}
+
+// GenerateElementAddress works out the array and index of ie and returns the memory operand of the element.
+func (g *X64Generator) GenerateElementAddress(ie *ast.IndexExpression) string {
+	tracer.Trace("GenerateElementAddress")
+	defer tracer.Untrace("GenerateElementAddress")
+	t := codegen.TypeOf(ie.Left, g)
+	if !codegen.IsArrayType(t) {
+		g.e(ie.Token, "cannot index "+ie.Left.String()+" of type "+t)
+	}
+	if it := codegen.TypeOf(ie.Index, g); it != "" && !codegen.IsIntegerType(it) {
+		g.e(ie.Token, "array index must be an int, got "+it)
+	}
+	base := g.GenerateExpression(ie.Left)
+	if base == NULLSTORAGE {
+		g.e(ie.Token, "array has no value: "+ie.Left.String())
+	}
+	base = g.KeepLive(base)
+	idx := g.GenerateExpression(ie.Index)
+	if idx == NULLSTORAGE {
+		g.e(ie.Token, "index has no value: "+ie.Index.String())
+	}
+	if g.BoundsChecks && codegen.ArrayLen(t) >= 0 {
+		g.GenerateBoundsCheck(ie.Token, idx, codegen.ArrayLen(t))
+	}
+	return fmt.Sprintf("(%s,%s,%d)", StorageLocs[base], StorageLocs[idx], g.SizeOf(codegen.ElementType(t)))
+}
+
+// KeepLive copies a variable's register into a temporary, as only temporaries are saved across a call
+// made while working out the rest of an expression.
+func (g *X64Generator) KeepLive(loc StorageLoc) StorageLoc {
+	if g.VirtualRegisters[loc] == "TEMP" {
+		return loc
+	}
+	tmp := g.GetFreeReg("TEMP")
+	g.out.WriteString("movq " + StorageLocs[loc] + ", " + StorageLocs[tmp] + "\n")
+	return tmp
+}
+
+// GenerateBoundsCheck aborts the program with the position of tok if idx is not below length.
+func (g *X64Generator) GenerateBoundsCheck(tok lex.LexedTok, idx StorageLoc, length int) {
+	tracer.Trace("GenerateBoundsCheck")
+	defer tracer.Untrace("GenerateBoundsCheck")
+	msgLabel := g.NewLabel()
+	msg := tok.Pos.String() + ": index out of bounds\n"
+	g.data.WriteString(msgLabel + ":\n")
+	g.data.WriteString(".ascii " + strconv.Quote(msg) + "\n")
+
+	// compared unsigned so negative indices are caught too
+	okLabel := g.NewLabel()
+	g.out.WriteString("cmpq $" + fmt.Sprintf("%d", length) + ", " + StorageLocs[idx] + "\n")
+	g.out.WriteString("jb " + okLabel + "\n")
+	// the program ends here, so registers and the stack can be clobbered
+	g.out.WriteString("andq $-16, %rsp\n")
+	g.out.WriteString("movq $2, %rdi\n")
+	g.out.WriteString("leaq " + msgLabel + "(%rip), %rsi\n")
+	g.out.WriteString("movq $" + fmt.Sprintf("%d", len(msg)) + ", %rdx\n")
+	g.out.WriteString("call write@PLT\n")
+	g.out.WriteString("movq $1, %rdi\n")
+	g.out.WriteString("call exit@PLT\n")
+	// only reachable from the check, so the registers still hold what they did before it
+	g.out.WriteString(okLabel + ":\n")
+}
+
+func (g *X64Generator) GenerateIndex(ie *ast.IndexExpression) StorageLoc {
+	tracer.Trace("GenerateIndex")
+	defer tracer.Untrace("GenerateIndex")
+	addr := g.GenerateElementAddress(ie)
+	reg := g.GetFreeReg("TEMP")
+	g.out.WriteString("movq " + addr + ", " + StorageLocs[reg] + "\n")
+	return reg
+}
+
+// GenerateFieldAddress works out the struct holding the field of fe and returns the memory operand of the field.
+func (g *X64Generator) GenerateFieldAddress(fe *ast.FieldExpression) (string, codegen.Field) {
+	tracer.Trace("GenerateFieldAddress")
+	defer tracer.Untrace("GenerateFieldAddress")
+	t := codegen.TypeOf(fe.Left, g)
+	// a pointer to a struct holds the address of the struct, which is what a struct evaluates to anyway
+	if codegen.IsPointerType(t) {
+		t = codegen.PointeeType(t)
+	}
+	st, ok := g.Structs[t]
+	if !ok {
+		g.e(fe.Token, "cannot access field "+fe.Field.Value+" of "+fe.Left.String()+" of type "+t)
+	}
+	f, ok := st.Field(fe.Field.Value)
+	if !ok {
+		g.e(fe.Token, t+" has no field "+fe.Field.Value)
+	}
+	base := g.GenerateExpression(fe.Left)
+	if base == NULLSTORAGE {
+		g.e(fe.Token, "struct has no value: "+fe.Left.String())
+	}
+	return fmt.Sprintf("%d(%s)", f.Offset, StorageLocs[base]), f
+}
+
+func (g *X64Generator) GenerateField(fe *ast.FieldExpression) StorageLoc {
+	tracer.Trace("GenerateField")
+	defer tracer.Untrace("GenerateField")
+	addr, f := g.GenerateFieldAddress(fe)
+	return g.LoadFrom(addr, f.Type)
+}
+
+// LoadFrom loads a value of type t from the memory operand addr, reading only as many bytes as t takes up in a struct.
+func (g *X64Generator) LoadFrom(addr string, t string) StorageLoc {
+	tracer.Trace("LoadFrom")
+	defer tracer.Untrace("LoadFrom")
+	reg := g.GetFreeReg("TEMP")
+	size, _, _ := codegen.Layout(t, g.Structs)
+	switch {
+	case g.ByAddress(t):
+		g.out.WriteString("leaq " + addr + ", " + StorageLocs[reg] + "\n")
+	case size == 4:
+		g.out.WriteString("movl " + addr + ", " + StorageLocs32[reg] + "\n")
+	case size == 1:
+		g.out.WriteString("movzbq " + addr + ", " + StorageLocs[reg] + "\n")
+	default:
+		g.out.WriteString("movq " + addr + ", " + StorageLocs[reg] + "\n")
+	}
+	return reg
+}
+
+// GeneratePointee works out the pointer p dereferences and returns the memory operand it points to.
+func (g *X64Generator) GeneratePointee(p *ast.PrefixExpression) string {
+	tracer.Trace("GeneratePointee")
+	defer tracer.Untrace("GeneratePointee")
+	if t := codegen.TypeOf(p.Right, g); !codegen.IsPointerType(t) {
+		g.e(p.Token, "cannot dereference "+p.Right.String()+" of type "+t)
+	}
+	ptr := g.GenerateExpression(p.Right)
+	if ptr == NULLSTORAGE {
+		g.e(p.Token, "pointer has no value: "+p.Right.String())
+	}
+	return "(" + StorageLocs[ptr] + ")"
+}
+
+// GenerateAddressOf takes the address of a variable, an array element, a struct field or a dereferenced pointer.
+func (g *X64Generator) GenerateAddressOf(p *ast.PrefixExpression) StorageLoc {
+	tracer.Trace("GenerateAddressOf")
+	defer tracer.Untrace("GenerateAddressOf")
+	var addr string
+	switch target := p.Right.(type) {
+	case *ast.Identifier:
+		addr = g.VarOperand(target.Token, target.Value)
+	case *ast.IndexExpression:
+		addr = g.GenerateElementAddress(target)
+	case *ast.FieldExpression:
+		addr, _ = g.GenerateFieldAddress(target)
+	case *ast.PrefixExpression:
+		if target.Operator != "*" {
+			g.e(p.Token, "cannot take the address of "+p.Right.String())
+		}
+		addr = g.GeneratePointee(target)
+	default:
+		g.e(p.Token, "cannot take the address of "+p.Right.String())
+	}
+	reg := g.GetFreeReg("TEMP")
+	g.out.WriteString("leaq " + addr + ", " + StorageLocs[reg] + "\n")
+	return reg
+}
+
+// GenerateAssignment stores a value in an array element, a struct field or through a pointer.
+func (g *X64Generator) GenerateAssignment(as *ast.AssignmentStatement) {
+	tracer.Trace("GenerateAssignment")
+	defer tracer.Untrace("GenerateAssignment")
+	t := codegen.TypeOf(as.Target, g)
+	if vt := codegen.TypeOf(as.Value, g); vt != "" && t != "" && vt != t && !(codegen.IsFloatType(vt) && codegen.IsFloatType(t)) {
+		g.e(as.Token, "cannot assign "+vt+" to "+as.Target.String()+" of type "+t)
+	}
+	if codegen.IsArrayType(t) && !codegen.IsArrayRef(t) {
+		g.e(as.Token, "cannot assign to array "+as.Target.String()+", assign to its elements instead")
+	}
+	sloc := g.GenerateConverted(as.Value, t)
+	if sloc == NULLSTORAGE {
+		g.e(as.Token, "assigned value has no value: "+as.Value.String())
+	}
+	sloc = g.KeepLive(sloc)
+	var addr string
+	size := 8
+	switch target := as.Target.(type) {
+	case *ast.IndexExpression:
+		addr = g.GenerateElementAddress(target)
+	case *ast.FieldExpression:
+		addr, _ = g.GenerateFieldAddress(target)
+		size, _, _ = codegen.Layout(t, g.Structs)
+	case *ast.PrefixExpression:
+		addr = g.GeneratePointee(target)
+		size, _, _ = codegen.Layout(t, g.Structs)
+	}
+	switch {
+	case g.ByAddress(t):
+		dest := g.GetFreeReg("TEMP")
+		g.out.WriteString("leaq " + addr + ", " + StorageLocs[dest] + "\n")
+		g.CopyMemory(StorageLocs[sloc], 0, StorageLocs[dest], 0, size)
+	case size == 4:
+		g.out.WriteString("movl " + StorageLocs32[sloc] + ", " + addr + "\n")
+	case size == 1:
+		g.out.WriteString("movb " + StorageLocs8[sloc] + ", " + addr + "\n")
+	default:
+		g.out.WriteString("movq " + StorageLocs[sloc] + ", " + addr + "\n")
+	}
+}
+
+func (g *X64Generator) GenerateCall(c *ast.CallExpression) StorageLoc {
 	tracer.Trace("GenerateCall")
 	defer tracer.Untrace("GenerateCall")
+	pc, err := codegen.PrintCall(c, g)
+	if err != nil {
+		g.e(c.Token, err.Error())
+	}
+	c = pc
+	sig, known := g.Functions[c.Function.Value]
+	if known {
+		if err := codegen.CheckCall(c, sig, g); err != nil {
+			g.e(c.Token, err.Error())
+		}
+	}
+	// temporaries of an enclosing expression don't survive the call, so save them on the stack
+	var live []StorageLoc
+	for _, reg := range Sls {
+		if g.VirtualRegisters[reg] == "TEMP" {
+			g.out.WriteString("pushq " + StorageLocs[reg] + "\n")
+			g.TempDepth += 8
+			live = append(live, reg)
+		}
+	}
+	g.VirtualRegisters = map[StorageLoc]string{}
+
+	// arguments are pushed as they are worked out, as working out a later one could clobber the registers of earlier ones.
+	// structs are pushed as their address
+	var types []string
 	for i, arg := range c.Arguments {
-		sloc := g.GenerateExpression(arg)
-		if sloc != NULLSTORAGE {
-			g.out.WriteString("movq " + StorageLocs[sloc] + ", " + StorageLocs[FNCallRegs[i]] + "\n")
+		var t string
+		if i < len(sig.Params) {
+			t = sig.Params[i]
+		} else if t = codegen.TypeOf(arg, g); t == "float" {
+			// floats passed as variadic arguments are promoted to doubles, as in C
+			t = "double"
+		}
+		sloc := g.GenerateConverted(arg, t)
+		if sloc == NULLSTORAGE {
+			g.e(c.Token, "argument has no value: "+arg.String())
+		}
+		g.out.WriteString("pushq " + StorageLocs[sloc] + "\n")
+		g.TempDepth += 8
+		delete(g.VirtualRegisters, sloc)
+		types = append(types, t)
+	}
+
+	// work out where each argument goes: the registers for each eightbyte, or nil for the stack
+	intArgs, floatArgs := 0, 0
+	result, returnsStruct := g.Structs[sig.ReturnType]
+	bigResult := returnsStruct && codegen.ClassifySysV(result, g.Structs) == nil
+	if bigResult {
+		// %rdi holds where to write the result
+		intArgs++
+	}
+	dests := make([][]string, len(types))
+	stackOffsets := make([]int, len(types))
+	stackSize := 0
+	for i, t := range types {
+		if st, ok := g.Structs[t]; ok {
+			dests[i] = g.StructRegs(st, intArgs, floatArgs)
+			if dests[i] == nil {
+				stackOffsets[i] = stackSize
+				stackSize += g.SizeOf(t)
+			}
+		} else if codegen.IsFloatType(t) && floatArgs < len(FloatCallRegs) {
+			dests[i] = []string{FloatCallRegs[floatArgs]}
+		} else if !codegen.IsFloatType(t) && intArgs < len(FNCallRegs) {
+			dests[i] = []string{StorageLocs[FNCallRegs[intArgs]]}
+		} else {
+			// anything left over once the registers run out goes on the stack
+			stackOffsets[i] = stackSize
+			stackSize += 8
+		}
+		for _, reg := range dests[i] {
+			if strings.HasPrefix(reg, "%xmm") {
+				floatArgs++
+			} else {
+				intArgs++
+			}
+		}
+	}
+	// the stack has to be 16 byte aligned at the call, which libc relies on, so padding goes above any stack arguments
+	below := stackSize
+	if (g.TempDepth+stackSize)%16 != 0 {
+		below += 8
+	}
+	if below > 0 {
+		g.out.WriteString("subq $" + fmt.Sprintf("%d", below) + ", %rsp\n")
+	}
+	// the pushed value of argument i is above the stack arguments
+	pushed := func(i int) string {
+		return fmt.Sprintf("%d(%%rsp)", below+8*(len(types)-1-i))
+	}
+	// %rax holds the address of each struct while it is copied, and the argument registers are taken as they are
+	// filled, so they are kept out of the way of the copying
+	g.VirtualRegisters[RAX] = "TEMP"
+	for _, reg := range FNCallRegs {
+		g.VirtualRegisters[reg] = "TEMP"
+	}
+	for i, t := range types {
+		switch {
+		case dests[i] == nil && g.Structs[t].Name != "":
+			g.out.WriteString("movq " + pushed(i) + ", %rax\n")
+			g.CopyMemory("%rax", 0, "%rsp", stackOffsets[i], g.Structs[t].Size)
+		case dests[i] == nil:
+			g.out.WriteString("movq " + pushed(i) + ", %rax\n")
+			g.out.WriteString("movq %rax, " + fmt.Sprintf("%d", stackOffsets[i]) + "(%rsp)\n")
+		case g.Structs[t].Name != "":
+			g.out.WriteString("movq " + pushed(i) + ", %rax\n")
+			for j, reg := range dests[i] {
+				g.out.WriteString("movq " + fmt.Sprintf("%d", j*8) + "(%rax), " + reg + "\n")
+			}
+		default:
+			g.out.WriteString("movq " + pushed(i) + ", " + dests[i][0] + "\n")
 		}
 	}
-	g.out.WriteString("call " + c.Function.Value + "\n")
-	for sl := range g.VirtualRegisters {
-		delete(g.VirtualRegisters, sl)
+	delete(g.VirtualRegisters, RAX)
+	if bigResult {
+		g.out.WriteString("leaq " + fmt.Sprintf("-%d", g.StructTemps[c]) + "(%rbp), %rdi\n")
+	}
+	if sig.Variadic {
+		// %al holds the number of vector registers used by a variadic call
+		g.out.WriteString("movq $" + fmt.Sprintf("%d", floatArgs) + ", %rax\n")
+	}
+	if sig.Extern {
+		// external symbols are resolved at link time through the PLT
+		g.out.WriteString("call " + c.Function.Value + "@PLT\n")
+	} else {
+		g.out.WriteString("call " + c.Function.Value + "\n")
+	}
+	g.out.WriteString("addq $" + fmt.Sprintf("%d", below+8*len(types)) + ", %rsp\n")
+	g.TempDepth -= 8 * len(types)
+	// float results come back in %xmm0 but callers expect every result in %rax
+	switch sig.ReturnType {
+	case "float":
+		g.out.WriteString("movd %xmm0, %eax\n")
+	case "double":
+		g.out.WriteString("movq %xmm0, %rax\n")
+	}
+	if returnsStruct && !bigResult {
+		// small structs come back in registers, so they are written out to give them an address
+		ints, floats := []string{"%rax", "%rdx"}, []string{"%xmm0", "%xmm1"}
+		for i, class := range codegen.ClassifySysV(result, g.Structs) {
+			reg := ints[0]
+			if class == "SSE" {
+				reg, floats = floats[0], floats[1:]
+			} else {
+				ints = ints[1:]
+			}
+			g.out.WriteString("movq " + reg + ", " + fmt.Sprintf("%d", i*8-g.StructTemps[c]) + "(%rbp)\n")
+		}
+		g.out.WriteString("leaq " + fmt.Sprintf("-%d", g.StructTemps[c]) + "(%rbp), %rax\n")
 	}
 
-	// g.out.WriteString("addq $" + fmt.Sprintf("%d", len(c.Arguments)*8) + ", %rsp\n")
+	// restore the saved temporaries, keeping the result out of their way
+	g.VirtualRegisters = map[StorageLoc]string{}
+	for _, reg := range live {
+		g.VirtualRegisters[reg] = "TEMP"
+	}
+	dest := g.GetFreeReg("TEMP")
+	if dest != RAX {
+		g.out.WriteString("movq %rax, " + StorageLocs[dest] + "\n")
+	}
+	for i := len(live) - 1; i >= 0; i-- {
+		g.out.WriteString("popq " + StorageLocs[live[i]] + "\n")
+		g.TempDepth -= 8
+	}
+	return dest
 }
 
 func (g *X64Generator) GenerateReturn(r *ast.ReturnStatement) {
 	tracer.Trace("GenerateReturn")
 	defer tracer.Untrace("GenerateReturn")
 	// clean up stack
-	sloc := g.GenerateExpression(r.ReturnValue)
-	if sloc != NULLSTORAGE && sloc != RAX {
+	if err := codegen.CheckAssign(r.ReturnValue, g.ReturnType, g); err != nil {
+		g.e(r.Token, err.Error())
+	}
+	sloc := g.GenerateConverted(r.ReturnValue, g.ReturnType)
+	st, isStruct := g.Structs[g.ReturnType]
+	switch {
+	case sloc == NULLSTORAGE:
+	case isStruct:
+		g.GenerateStructReturn(sloc, st)
+	case g.ReturnType == "float":
+		g.out.WriteString("movd " + StorageLocs32[sloc] + ", %xmm0\n")
+	case g.ReturnType == "double":
+		g.out.WriteString("movq " + StorageLocs[sloc] + ", %xmm0\n")
+	case sloc != RAX:
 		g.out.WriteString("movq " + StorageLocs[sloc] + ", %rax\n")
 	}
-	g.out.WriteString("movq %rbp, %rsp\n")
-	g.out.WriteString("popq %rbp\n")
-	g.out.WriteString("ret\n")
+	g.out.WriteString("jmp " + g.ReturnLabel + "\n")
+}
+
+// GenerateStructReturn puts the struct at the address in src where the caller expects it,
+// either in registers or copied to the space the caller passed a pointer to.
+func (g *X64Generator) GenerateStructReturn(src StorageLoc, st codegen.Struct) {
+	tracer.Trace("GenerateStructReturn")
+	defer tracer.Untrace("GenerateStructReturn")
+	g.out.WriteString("movq " + StorageLocs[src] + ", %rsi\n")
+	if g.ResultPtr != 0 {
+		g.out.WriteString("movq " + fmt.Sprintf("-%d", g.ResultPtr) + "(%rbp), %rdi\n")
+		g.CopyMemory("%rsi", 0, "%rdi", 0, st.Size)
+		g.out.WriteString("movq %rdi, %rax\n")
+		return
+	}
+	ints, floats := []string{"%rax", "%rdx"}, []string{"%xmm0", "%xmm1"}
+	for i, class := range codegen.ClassifySysV(st, g.Structs) {
+		reg := ints[0]
+		if class == "SSE" {
+			reg, floats = floats[0], floats[1:]
+		} else {
+			ints = ints[1:]
+		}
+		g.out.WriteString("movq " + fmt.Sprintf("%d", i*8) + "(%rsi), " + reg + "\n")
+	}
 }
 
 func (g *X64Generator) GenerateExit(e *ast.Node) {
@@ -328,6 +1179,23 @@ func (g *X64Generator) GenerateExit(e *ast.Node) {
 	g.out.WriteString("syscall\n")
 }
 
+// NewLabel reserves a label without placing it, for jumps to code that has not been generated yet.
+func (g *X64Generator) NewLabel() string {
+	g.LabelCounter++
+	return fmt.Sprintf(".L%d", g.LabelCounter-1)
+}
+
+// PlaceLabel emits a label reserved by NewLabel. Control can reach it from elsewhere so variables may not be in the registers
+// they were loaded into, but the temporaries of an enclosing expression are still live.
+func (g *X64Generator) PlaceLabel(label string) {
+	g.out.WriteString(label + ":\n")
+	for reg, owner := range g.VirtualRegisters {
+		if owner != "TEMP" {
+			delete(g.VirtualRegisters, reg)
+		}
+	}
+}
+
 func (g *X64Generator) GenerateLabel() string {
 	tracer.Trace("GenerateLabel")
 	defer tracer.Untrace("GenerateLabel")
@@ -339,6 +1207,27 @@ func (g *X64Generator) GenerateLabel() string {
 func (g *X64Generator) GenerateInfix(node *ast.InfixExpression) StorageLoc {
 	tracer.Trace("GenerateInfix")
 	defer tracer.Untrace("GenerateInfix")
+	if expr, ok := codegen.PointerArithmetic(node, g); ok {
+		return g.GenerateExpression(expr)
+	}
+	if expr, ok := codegen.StringConcat(node, g); ok {
+		return g.GenerateExpression(expr)
+	}
+	if codegen.IsComparison(node.Operator) {
+		return g.GenerateComparison(node)
+	}
+	if node.Operator == "&&" || node.Operator == "||" {
+		return g.GenerateLogical(node)
+	}
+	if t, isFloat := g.FloatOperandType(node); isFloat {
+		return g.GenerateFloatInfix(node, t)
+	}
+	switch node.Operator {
+	case "/", "%":
+		return g.GenerateDivision(node)
+	case "<<", ">>":
+		return g.GenerateShift(node)
+	}
 	leftS, rightS, destLoc := g.GetInfixOperands(node)
 
 	switch node.Operator {
@@ -348,69 +1237,357 @@ func (g *X64Generator) GenerateInfix(node *ast.InfixExpression) StorageLoc {
 		g.out.WriteString("subq ")
 	case "*":
 		g.out.WriteString("imulq ")
-		// TODO: implement division
+	case "^":
+		g.out.WriteString("xorq ")
+	case "&":
+		g.out.WriteString("andq ")
+	case "|":
+		g.out.WriteString("orq ")
 	}
 	g.out.WriteString(rightS + ", " + leftS + "\n")
+	g.FreeOperand(rightS)
 	return destLoc
 }
 
+// GenerateDivision generates signed integer division or remainder.
+// idivq divides %rdx:%rax by its operand, leaving the quotient in %rax and the remainder in %rdx.
+func (g *X64Generator) GenerateDivision(node *ast.InfixExpression) StorageLoc {
+	tracer.Trace("GenerateDivision")
+	defer tracer.Untrace("GenerateDivision")
+	left := g.GenerateExpression(node.Left)
+	right := g.GenerateExpression(node.Right)
+	dest := g.GetFreeReg("TEMP")
+	// anything else in %rax and %rdx may still be needed by the rest of the expression, so save it
+	var saved []StorageLoc
+	for _, reg := range []StorageLoc{RAX, RDX} {
+		if _, ok := g.VirtualRegisters[reg]; ok && reg != dest {
+			g.out.WriteString("pushq " + StorageLocs[reg] + "\n")
+			saved = append(saved, reg)
+		}
+	}
+	// the divisor goes on the stack, as it could be in either of the registers being overwritten
+	g.out.WriteString("pushq " + StorageLocs[right] + "\n")
+	g.out.WriteString("movq " + StorageLocs[left] + ", %rax\n")
+	g.out.WriteString("cqto\n") // sign extend %rax into %rdx
+	g.out.WriteString("idivq (%rsp)\n")
+	g.out.WriteString("addq $8, %rsp\n")
+	if node.Operator == "%" {
+		g.out.WriteString("movq %rdx, " + StorageLocs[dest] + "\n")
+	} else {
+		g.out.WriteString("movq %rax, " + StorageLocs[dest] + "\n")
+	}
+	for i := len(saved) - 1; i >= 0; i-- {
+		g.out.WriteString("popq " + StorageLocs[saved[i]] + "\n")
+	}
+	delete(g.VirtualRegisters, left)
+	delete(g.VirtualRegisters, right)
+	return dest
+}
+
+// GenerateShift generates an arithmetic shift, which can only take its count as an immediate or from %cl.
+func (g *X64Generator) GenerateShift(node *ast.InfixExpression) StorageLoc {
+	tracer.Trace("GenerateShift")
+	defer tracer.Untrace("GenerateShift")
+	op := "salq "
+	if node.Operator == ">>" {
+		op = "sarq "
+	}
+	leftS, rightS, destLoc := g.GetInfixOperands(node)
+	if strings.HasPrefix(rightS, "$") {
+		g.out.WriteString(op + rightS + ", " + leftS + "\n")
+		return destLoc
+	}
+	defer g.FreeOperand(rightS)
+	if destLoc == RCX {
+		// %rcx is about to hold the count, so the value being shifted has to go somewhere else
+		moved := g.GetFreeReg("TEMP")
+		g.out.WriteString("movq %rcx, " + StorageLocs[moved] + "\n")
+		delete(g.VirtualRegisters, RCX)
+		destLoc = moved
+	}
+	_, saveRCX := g.VirtualRegisters[RCX]
+	if saveRCX {
+		g.out.WriteString("pushq %rcx\n")
+	}
+	g.out.WriteString("movq " + rightS + ", %rcx\n")
+	g.out.WriteString(op + "%cl, " + StorageLocs[destLoc] + "\n")
+	if saveRCX {
+		g.out.WriteString("popq %rcx\n")
+	}
+	return destLoc
+}
+
+// FloatOperandType returns the type a float infix expression is worked out in, and false if it is not a float expression.
+func (g *X64Generator) FloatOperandType(node *ast.InfixExpression) (string, bool) {
+	left, right := codegen.TypeOf(node.Left, g), codegen.TypeOf(node.Right, g)
+	if !codegen.IsFloatType(left) && !codegen.IsFloatType(right) {
+		return "", false
+	}
+	t, err := codegen.OperandType(left, right)
+	if err != nil {
+		g.e(node.Token, err.Error())
+	}
+	return t, true
+}
+
+// LoadFloatOperands puts the left operand of node into %xmm14 and the right one into %xmm15, both as type t.
+// Those are used as scratch registers so that they never clobber float arguments being set up for a call.
+func (g *X64Generator) LoadFloatOperands(node *ast.InfixExpression, t string) {
+	tracer.Trace("LoadFloatOperands")
+	defer tracer.Untrace("LoadFloatOperands")
+	left := g.GenerateConverted(node.Left, t)
+	right := g.GenerateConverted(node.Right, t)
+	if t == "float" {
+		g.out.WriteString("movd " + StorageLocs32[left] + ", %xmm14\n")
+		g.out.WriteString("movd " + StorageLocs32[right] + ", %xmm15\n")
+	} else {
+		g.out.WriteString("movq " + StorageLocs[left] + ", %xmm14\n")
+		g.out.WriteString("movq " + StorageLocs[right] + ", %xmm15\n")
+	}
+	delete(g.VirtualRegisters, left)
+	delete(g.VirtualRegisters, right)
+}
+
+func (g *X64Generator) GenerateFloatInfix(node *ast.InfixExpression, t string) StorageLoc {
+	tracer.Trace("GenerateFloatInfix")
+	defer tracer.Untrace("GenerateFloatInfix")
+	g.LoadFloatOperands(node, t)
+	// ss works on single precision and sd on double precision
+	suffix := "sd"
+	if t == "float" {
+		suffix = "ss"
+	}
+	switch node.Operator {
+	case "+":
+		g.out.WriteString("add" + suffix + " %xmm15, %xmm14\n")
+	case "-":
+		g.out.WriteString("sub" + suffix + " %xmm15, %xmm14\n")
+	case "*":
+		g.out.WriteString("mul" + suffix + " %xmm15, %xmm14\n")
+	case "/":
+		g.out.WriteString("div" + suffix + " %xmm15, %xmm14\n")
+	default:
+		g.e(node.Token, "unsupported operator for "+t+": "+node.Operator)
+	}
+	dest := g.GetFreeReg("TEMP")
+	if t == "float" {
+		g.out.WriteString("movd %xmm14, " + StorageLocs32[dest] + "\n")
+	} else {
+		g.out.WriteString("movq %xmm14, " + StorageLocs[dest] + "\n")
+	}
+	return dest
+}
+
 func (g *X64Generator) GetInfixOperands(node *ast.InfixExpression) (string, string, StorageLoc) {
 	tracer.Trace("GetInfixOperands")
 	defer tracer.Untrace("GetInfixOperands")
-	var leftS, rightS string
-	var leftLoc StorageLoc // TODO: fix bug with something like int y = x*8 where x would be
-	switch left := node.Left.(type) {
-	case *ast.Identifier:
-		leftLoc = g.GenerateIdentifier(left)
-		leftS = StorageLocs[leftLoc]
-	case *ast.InfixExpression:
-		leftS = StorageLocs[g.GenerateInfix(left)]
-	case *ast.IntegerLiteral:
-		// leftS = "$" + fmt.Sprintf("%d", left.Value)
-		leftLoc = g.GenerateIntegerLiteral(left)
-		leftS = StorageLocs[leftLoc]
-	case *ast.CallExpression:
-		g.GenerateCall(left)
-		leftS = StorageLocs[RAX]
+	var rightS string
+	leftLoc := g.GenerateExpression(node.Left)
+	if leftLoc == NULLSTORAGE {
+		g.e(node.Token, "left operand has no value: "+node.Left.String())
+	}
+	// the result is written over the left operand, so a variable's register must be copied rather than overwritten
+	if g.VirtualRegisters[leftLoc] != "TEMP" {
+		tmp := g.GetFreeReg("TEMP")
+		g.out.WriteString("movq " + StorageLocs[leftLoc] + ", " + StorageLocs[tmp] + "\n")
+		// variables are always kept in memory too, so the register can be given up and reloaded when needed
+		delete(g.VirtualRegisters, leftLoc)
+		leftLoc = tmp
 	}
 
 	switch right := node.Right.(type) {
-	case *ast.Identifier:
-		rightS = StorageLocs[g.GenerateIdentifier(right)]
 	case *ast.IntegerLiteral:
 		rightS = "$" + fmt.Sprintf("%d", right.Value)
-	case *ast.InfixExpression:
-		rightS = StorageLocs[g.GenerateInfix(right)]
-	case *ast.CallExpression:
-		g.GenerateCall(right)
-		rightS = StorageLocs[RAX]
+	default:
+		rightLoc := g.GenerateExpression(right)
+		if rightLoc == NULLSTORAGE {
+			g.e(node.Token, "right operand has no value: "+node.Right.String())
+		}
+		rightS = StorageLocs[rightLoc]
 	}
-	return leftS, rightS, leftLoc
+	return StorageLocs[leftLoc], rightS, leftLoc
 }
 
-func (g *X64Generator) GenerateIntegerLiteral(il *ast.IntegerLiteral) StorageLoc {
-	var sloc StorageLoc
-	for _, v := range Sls {
-		_, ok := g.VirtualRegisters[v]
-		if !ok {
-			g.VirtualRegisters[v] = "TEMP"
-			sloc = v
-			break
-		}
+// FreeOperand gives up the register an operand returned by GetInfixOperands was in, once it has been used.
+func (g *X64Generator) FreeOperand(operand string) {
+	if i := slices.Index(StorageLocs, operand); i >= 0 {
+		delete(g.VirtualRegisters, StorageLoc(i))
 	}
+}
+
+func (g *X64Generator) GenerateIntegerLiteral(il *ast.IntegerLiteral) StorageLoc {
+	sloc := g.GetFreeReg("TEMP")
 
 	g.out.WriteString("movq $" + fmt.Sprintf("%d", il.Value) + ", " + StorageLocs[sloc] + "\n")
 
 	return sloc
 }
 
+// GenerateFloatLiteral loads the bit pattern of fl as type t, which is either float or double.
+func (g *X64Generator) GenerateFloatLiteral(fl *ast.FloatLiteral, t string) StorageLoc {
+	sloc := g.GetFreeReg("TEMP")
+	if t == "float" {
+		g.out.WriteString("movl $" + fmt.Sprintf("%d", math.Float32bits(float32(fl.Value))) + ", " + StorageLocs32[sloc] + "\n")
+	} else {
+		g.out.WriteString("movabsq $" + fmt.Sprintf("%d", math.Float64bits(fl.Value)) + ", " + StorageLocs[sloc] + "\n")
+	}
+	return sloc
+}
+
+// GenerateConverted generates expr and converts the result between float and double if it is to be used as type to.
+func (g *X64Generator) GenerateConverted(expr ast.Expression, to string) StorageLoc {
+	tracer.Trace("GenerateConverted")
+	defer tracer.Untrace("GenerateConverted")
+	if fl, ok := expr.(*ast.FloatLiteral); ok && codegen.IsFloatType(to) {
+		return g.GenerateFloatLiteral(fl, to)
+	}
+	from := codegen.TypeOf(expr, g)
+	sloc := g.GenerateExpression(expr)
+	if sloc == NULLSTORAGE || from == to || !codegen.IsFloatType(from) || !codegen.IsFloatType(to) {
+		return sloc
+	}
+	return g.Convert(sloc, from, to)
+}
+
+func (g *X64Generator) GeneratePrefix(p *ast.PrefixExpression) StorageLoc {
+	tracer.Trace("GeneratePrefix")
+	defer tracer.Untrace("GeneratePrefix")
+	switch p.Operator {
+	case "&":
+		return g.GenerateAddressOf(p)
+	case "*":
+		return g.LoadFrom(g.GeneratePointee(p), codegen.TypeOf(p, g))
+	}
+	t := codegen.TypeOf(p.Right, g)
+	src := g.GenerateExpression(p.Right)
+	if src == NULLSTORAGE {
+		g.e(p.Token, "operand has no value: "+p.Right.String())
+	}
+	dest := g.GetFreeReg("TEMP")
+	switch {
+	case p.Operator == "!":
+		g.out.WriteString("cmpq $0, " + StorageLocs[src] + "\n")
+		g.out.WriteString("sete " + StorageLocs8[dest] + "\n")
+		g.out.WriteString("movzbq " + StorageLocs8[dest] + ", " + StorageLocs[dest] + "\n")
+	case p.Operator == "-" && t == "float":
+		// negating a float just flips its sign bit
+		g.out.WriteString("movq " + StorageLocs[src] + ", " + StorageLocs[dest] + "\n")
+		g.out.WriteString("btcl $31, " + StorageLocs32[dest] + "\n")
+	case p.Operator == "-" && t == "double":
+		g.out.WriteString("movq " + StorageLocs[src] + ", " + StorageLocs[dest] + "\n")
+		g.out.WriteString("btcq $63, " + StorageLocs[dest] + "\n")
+	case p.Operator == "-":
+		g.out.WriteString("movq " + StorageLocs[src] + ", " + StorageLocs[dest] + "\n")
+		g.out.WriteString("negq " + StorageLocs[dest] + "\n")
+	case p.Operator == "~" && !codegen.IsFloatType(t):
+		g.out.WriteString("movq " + StorageLocs[src] + ", " + StorageLocs[dest] + "\n")
+		g.out.WriteString("notq " + StorageLocs[dest] + "\n")
+	default:
+		g.e(p.Token, "unsupported operator for "+t+": "+p.Operator)
+	}
+	return dest
+}
+
+// StringData adds s to the read only data, laid out as a string, and returns the label of its first byte.
+func (g *X64Generator) StringData(s string) string {
+	label := g.NewLabel()
+	g.data.WriteString(".p2align 3\n")
+	g.data.WriteString(".quad " + fmt.Sprintf("%d", len(s)) + "\n")
+	g.data.WriteString(label + ":\n")
+	g.data.WriteString(".ascii " + codegen.AsmString(s+"\x00") + "\n")
+	return label
+}
+
+func (g *X64Generator) GenerateStringLiteral(sl *ast.StringLiteral) StorageLoc {
+	tracer.Trace("GenerateStringLiteral")
+	defer tracer.Untrace("GenerateStringLiteral")
+	label := g.StringData(sl.Value)
+	reg := g.GetFreeReg("TEMP")
+	g.out.WriteString("leaq " + label + "(%rip), " + StorageLocs[reg] + "\n")
+	return reg
+}
+
+func (g *X64Generator) GenerateBoolean(b *ast.Boolean) StorageLoc {
+	sloc := g.GetFreeReg("TEMP")
+	if b.Value {
+		g.out.WriteString("movq $1, " + StorageLocs[sloc] + "\n")
+	} else {
+		g.out.WriteString("movq $0, " + StorageLocs[sloc] + "\n")
+	}
+	return sloc
+}
+
+func (g *X64Generator) GenerateCast(c *ast.CastExpression) StorageLoc {
+	tracer.Trace("GenerateCast")
+	defer tracer.Untrace("GenerateCast")
+	from := codegen.TypeOf(c.Left, g)
+	to := c.Type.Value
+	if !codegen.CastAllowed(from, to) {
+		g.e(c.Token, "cannot cast "+from+" to "+to)
+	}
+	src := g.GenerateExpression(c.Left)
+	if src == NULLSTORAGE {
+		g.e(c.Token, "cannot cast expression with no value: "+c.Left.String())
+	}
+	return g.Convert(src, from, to)
+}
+
+// Convert converts the value in src from one scalar type to another, leaving the result in a new register.
+func (g *X64Generator) Convert(src StorageLoc, from, to string) StorageLoc {
+	tracer.Trace("Convert")
+	defer tracer.Untrace("Convert")
+	// floats are kept as their bit pattern in general purpose registers and only moved into SSE registers to be worked on
+	dest := g.GetFreeReg("TEMP")
+	switch {
+	case from == to || from == "":
+		g.out.WriteString("movq " + StorageLocs[src] + ", " + StorageLocs[dest] + "\n")
+	case to == "bool":
+		switch from {
+		case "float":
+			g.out.WriteString("movd " + StorageLocs32[src] + ", %xmm14\n")
+			g.out.WriteString("xorps %xmm15, %xmm15\n")
+			g.out.WriteString("ucomiss %xmm15, %xmm14\n")
+		case "double":
+			g.out.WriteString("movq " + StorageLocs[src] + ", %xmm14\n")
+			g.out.WriteString("xorpd %xmm15, %xmm15\n")
+			g.out.WriteString("ucomisd %xmm15, %xmm14\n")
+		default:
+			g.out.WriteString("cmpq $0, " + StorageLocs[src] + "\n")
+		}
+		g.out.WriteString("setne " + StorageLocs8[dest] + "\n")
+		g.out.WriteString("movzbq " + StorageLocs8[dest] + ", " + StorageLocs[dest] + "\n")
+	case from == "float" && to == "double":
+		g.out.WriteString("movd " + StorageLocs32[src] + ", %xmm14\n")
+		g.out.WriteString("cvtss2sd %xmm14, %xmm14\n")
+		g.out.WriteString("movq %xmm14, " + StorageLocs[dest] + "\n")
+	case from == "double" && to == "float":
+		g.out.WriteString("movq " + StorageLocs[src] + ", %xmm14\n")
+		g.out.WriteString("cvtsd2ss %xmm14, %xmm14\n")
+		g.out.WriteString("movd %xmm14, " + StorageLocs32[dest] + "\n")
+	case from == "float":
+		g.out.WriteString("movd " + StorageLocs32[src] + ", %xmm14\n")
+		g.out.WriteString("cvttss2siq %xmm14, " + StorageLocs[dest] + "\n")
+	case from == "double":
+		g.out.WriteString("movq " + StorageLocs[src] + ", %xmm14\n")
+		g.out.WriteString("cvttsd2siq %xmm14, " + StorageLocs[dest] + "\n")
+	case to == "float":
+		g.out.WriteString("cvtsi2ssq " + StorageLocs[src] + ", %xmm14\n")
+		g.out.WriteString("movd %xmm14, " + StorageLocs32[dest] + "\n")
+	case to == "double":
+		g.out.WriteString("cvtsi2sdq " + StorageLocs[src] + ", %xmm14\n")
+		g.out.WriteString("movq %xmm14, " + StorageLocs[dest] + "\n")
+	default:
+		// between integer types and from bool, which is already 0 or 1
+		g.out.WriteString("movq " + StorageLocs[src] + ", " + StorageLocs[dest] + "\n")
+	}
+	return dest
+}
+
 func (g *X64Generator) GenerateIf(i *ast.IfExpression) {
 	tracer.Trace("GenerateIf")
 	defer tracer.Untrace("GenerateIf")
 	// check if condition is true
 	// to do this, check what the comparative expr is and generate the corresponding jump instruction
-	separator := i.Condition.(*ast.InfixExpression).Operator
-	leftS, rightS, _ := g.GetInfixOperands(i.Condition.(*ast.InfixExpression))
 
 	// cmpl left, right
 	// jump to true case label
@@ -427,50 +1604,68 @@ func (g *X64Generator) GenerateIf(i *ast.IfExpression) {
 	// .L2:
 	// ...
 
-	predictedTrueLabel := fmt.Sprintf(".L%d", g.LabelCounter)
-	predictedEndLabel := fmt.Sprintf(".L%d", g.LabelCounter+1)
-
-	g.out.WriteString("cmpq " + rightS + ", " + leftS + "\n")
-	switch separator {
-	case "==":
-		g.out.WriteString("je " + predictedTrueLabel + "\n")
-	case "!=":
-		g.out.WriteString("jne " + predictedTrueLabel + "\n")
-	case "<":
-		g.out.WriteString("jl " + predictedTrueLabel + "\n")
-	case ">":
-		g.out.WriteString("jg " + predictedTrueLabel + "\n")
-	case "<=":
-		g.out.WriteString("jle " + predictedTrueLabel + "\n")
-	case ">=":
-		g.out.WriteString("jge " + predictedTrueLabel + "\n")
+	// else if chains test every condition in turn, jumping to the first true one, so they share one end label
+	conditions := []ast.Expression{i.Condition}
+	consequences := []*ast.BlockStatement{i.Consequence}
+	for _, e := range i.ElseIfs {
+		conditions = append(conditions, e.Condition)
+		consequences = append(consequences, e.Consequence)
 	}
-	g.GenerateBlock(i.Alternative)
-	g.out.WriteString("jmp " + predictedEndLabel + "\n")
-	g.GenerateLabel()
-	g.GenerateBlock(i.Consequence)
-	g.GenerateLabel()
+	trueLabels := []string{}
+	for range conditions {
+		trueLabels = append(trueLabels, g.NewLabel())
+	}
+	endLabel := g.NewLabel()
 
+	for n, c := range conditions {
+		g.GenerateConditionalJump(i.Token, c, trueLabels[n], true)
+	}
+	if i.Alternative != nil {
+		g.GenerateBlock(i.Alternative)
+	}
+	g.out.WriteString("jmp " + endLabel + "\n")
+	for n, consequence := range consequences {
+		g.PlaceLabel(trueLabels[n])
+		g.GenerateBlock(consequence)
+		if n < len(consequences)-1 {
+			g.out.WriteString("jmp " + endLabel + "\n")
+		}
+	}
+	g.PlaceLabel(endLabel)
 }
 
 func (g *X64Generator) GenerateVarReassignment(v *ast.VarReassignmentStatement) {
 	// TODO: this may need a bit of rewriting
 	tracer.Trace("GenerateVarReassignment")
 	defer tracer.Untrace("GenerateVarReassignment")
-	// find the variable's location in the stack
-	offset := g.GetVarStackOffset(v.Name.Value)
+	t := g.VarType(v.Name.Value)
+	if codegen.IsArrayType(t) && !codegen.IsArrayRef(t) {
+		g.e(v.Token, "cannot assign to array "+v.Name.Value+", assign to its elements instead")
+	}
+	// find where the variable is kept, on the stack or in the data section
+	dest := g.VarOperand(v.Token, v.Name.Value)
+	if st, ok := g.Structs[t]; ok {
+		if vt := codegen.TypeOf(v.Value, g); vt != t {
+			g.e(v.Token, "cannot assign "+vt+" to "+v.Name.Value+" of type "+t)
+		}
+		src := g.GenerateExpression(v.Value)
+		addr := g.GetFreeReg("TEMP")
+		g.out.WriteString("leaq " + dest + ", " + StorageLocs[addr] + "\n")
+		g.CopyMemory(StorageLocs[src], 0, StorageLocs[addr], 0, st.Size)
+		return
+	}
+	if err := codegen.CheckAssign(v.Value, t, g); err != nil {
+		g.e(v.Token, err.Error())
+	}
 	// update it with the new value
-	switch v.Value.(type) {
+	switch value := v.Value.(type) {
 	case *ast.IntegerLiteral:
-		g.out.WriteString("movq $" + fmt.Sprintf("%d", v.Value.(*ast.IntegerLiteral).Value) + ", " + fmt.Sprintf("-%d(%%rbp)", offset) + "\n")
-	case *ast.Identifier:
-		g.out.WriteString("movq " + StorageLocs[g.GenerateIdentifier(v.Value.(*ast.Identifier))] + ", " + fmt.Sprintf("-%d(%%rbp)", offset) + "\n")
-	case *ast.CallExpression:
-		g.GenerateCall(v.Value.(*ast.CallExpression))
-		g.out.WriteString("movq " + "%rax" + ", " + fmt.Sprintf("-%d(%%rbp)", offset) + "\n")
-	case *ast.InfixExpression:
-		sloc := g.GenerateInfix(v.Value.(*ast.InfixExpression))
-		g.out.WriteString("movq " + StorageLocs[sloc] + ", " + fmt.Sprintf("-%d(%%rbp)", offset) + "\n")
+		g.out.WriteString("movq $" + fmt.Sprintf("%d", value.Value) + ", " + dest + "\n")
+	default:
+		sloc := g.GenerateConverted(value, g.VarType(v.Name.Value))
+		if sloc != NULLSTORAGE {
+			g.out.WriteString("movq " + StorageLocs[sloc] + ", " + dest + "\n")
+		}
 	}
 	// remove the old value from any registers
 	sloc, _ := g.GetVarStorageLoc(v.Name.Value)
@@ -495,33 +1690,246 @@ func (g *X64Generator) GenerateWhileLoop(w *ast.WhileExpression) {
 	// jle .L2
 	// ...
 
-	predictedBodyLabel := fmt.Sprintf(".L%d", g.LabelCounter)
-	predictedConditionLabel := fmt.Sprintf(".L%d", g.LabelCounter+1)
+	bodyLabel := g.NewLabel()
+	conditionLabel := g.NewLabel()
+	endLabel := g.NewLabel()
 
-	g.out.WriteString("jmp " + predictedConditionLabel + "\n")
+	g.out.WriteString("jmp " + conditionLabel + "\n")
 
-	g.GenerateLabel()
+	g.PlaceLabel(bodyLabel)
+	g.Loops.Push(codegen.LoopContext{ContinueLabel: conditionLabel, BreakLabel: endLabel})
 	g.GenerateBlock(w.Body)
+	g.Loops.Pop()
+
+	g.PlaceLabel(conditionLabel)
+	g.GenerateConditionalJump(w.Token, w.Condition, bodyLabel, true)
+	// falling through the condition means the loop finished without a break
+	if w.Alternative != nil {
+		g.GenerateBlock(w.Alternative)
+	}
+	g.PlaceLabel(endLabel)
+}
+
+func (g *X64Generator) GenerateForLoop(f *ast.ForExpression) {
+	tracer.Trace("GenerateForLoop")
+	defer tracer.Untrace("GenerateForLoop")
+	// same layout as a while loop, with the loop variable in its own slot of the frame, which is free to reuse after it
+	// e.g.
+	// movq %rax, -8(%rbp)	; int i = start
+	// jmp .L2
+	// .L1:
+	// ...
+	// movq %rax, -8(%rbp)	; i = i + step
+	// .L2:
+	// cmpq end, i
+	// jl .L1
+
+	scope := g.EnterScope()
+	g.GenerateVarDef(f.Init)
+	g.VirtualRegisters = map[StorageLoc]string{}
+
+	bodyLabel := g.NewLabel()
+	stepLabel := g.NewLabel()
+	conditionLabel := g.NewLabel()
+	endLabel := g.NewLabel()
+	loopVar := f.Init.Name
+
+	g.out.WriteString("jmp " + conditionLabel + "\n")
+	g.PlaceLabel(bodyLabel)
+	g.Loops.Push(codegen.LoopContext{ContinueLabel: stepLabel, BreakLabel: endLabel})
+	g.GenerateBlock(f.Body)
+	g.Loops.Pop()
+	g.PlaceLabel(stepLabel)
+	g.GenerateVarReassignment(&ast.VarReassignmentStatement{
+		Token: f.Token,
+		Name:  loopVar,
+		Value: &ast.InfixExpression{Token: f.Token, Left: loopVar, Operator: "+", Right: f.Step},
+	})
+
+	g.PlaceLabel(conditionLabel)
+	g.GenerateConditionalJump(f.Token, codegen.ForCondition(f), bodyLabel, true)
+	if f.Alternative != nil {
+		g.GenerateBlock(f.Alternative)
+	}
+	g.PlaceLabel(endLabel)
+	g.ExitScope(scope)
+}
+
+func (g *X64Generator) GenerateBreak(b *ast.BreakStatement) {
+	tracer.Trace("GenerateBreak")
+	defer tracer.Untrace("GenerateBreak")
+	if g.Loops.Size() == 0 {
+		g.e(b.Token, "break outside of loop")
+	}
+	g.out.WriteString("jmp " + g.Loops.Peek().BreakLabel + "\n")
+}
+
+func (g *X64Generator) GenerateContinue(c *ast.ContinueStatement) {
+	tracer.Trace("GenerateContinue")
+	defer tracer.Untrace("GenerateContinue")
+	if g.Loops.Size() == 0 {
+		g.e(c.Token, "continue outside of loop")
+	}
+	g.out.WriteString("jmp " + g.Loops.Peek().ContinueLabel + "\n")
+}
 
-	g.GenerateLabel()
-	separator := w.Condition.(*ast.InfixExpression).Operator
-	leftS, rightS, _ := g.GetInfixOperands(w.Condition.(*ast.InfixExpression))
+// GenerateCompare compares the operands of c and returns the condition code that holds if the comparison does,
+// which is the suffix of the jcc and setcc instructions.
+func (g *X64Generator) GenerateCompare(c *ast.InfixExpression) string {
+	tracer.Trace("GenerateCompare")
+	defer tracer.Untrace("GenerateCompare")
+	if cmp, ok := codegen.StringComparison(c, g); ok {
+		c = cmp
+	}
+	if t, isFloat := g.FloatOperandType(c); isFloat {
+		g.LoadFloatOperands(c, t)
+		op := "ucomisd "
+		if t == "float" {
+			op = "ucomiss "
+		}
+		// float comparisons set the flags like an unsigned comparison, and set all of zf, pf and cf when either side
+		// is NaN. a and ae are false then, unlike b and be, so < and <= compare the other way round
+		left, right := "%xmm14", "%xmm15"
+		if c.Operator == "<" || c.Operator == "<=" {
+			left, right = right, left
+		}
+		g.out.WriteString(op + right + ", " + left + "\n")
+		switch c.Operator {
+		case "==":
+			return "fe"
+		case "!=":
+			return "fne"
+		case "<", ">":
+			return "a"
+		}
+		return "ae"
+	}
+	leftS, rightS, left := g.GetInfixOperands(c)
 	g.out.WriteString("cmpq " + rightS + ", " + leftS + "\n")
-	switch separator {
+	g.FreeOperand(rightS)
+	delete(g.VirtualRegisters, left)
+	switch c.Operator {
 	case "==":
-		g.out.WriteString("je " + predictedBodyLabel + "\n")
+		return "e"
 	case "!=":
-		g.out.WriteString("jne " + predictedBodyLabel + "\n")
+		return "ne"
 	case "<":
-		g.out.WriteString("jl " + predictedBodyLabel + "\n")
+		return "l"
 	case ">":
-		g.out.WriteString("jg " + predictedBodyLabel + "\n")
+		return "g"
 	case "<=":
-		g.out.WriteString("jle " + predictedBodyLabel + "\n")
-	case ">=":
-		g.out.WriteString("jge " + predictedBodyLabel + "\n")
+		return "le"
 	}
+	return "ge"
+}
+
+// GenerateComparison materialises the result of a comparison as 0 or 1.
+func (g *X64Generator) GenerateComparison(c *ast.InfixExpression) StorageLoc {
+	tracer.Trace("GenerateComparison")
+	defer tracer.Untrace("GenerateComparison")
+	cc := g.GenerateCompare(c)
+	dest := g.GetFreeReg("TEMP")
+	switch cc {
+	case "fe", "fne":
+		// NaN is unequal to everything, which the parity flag tells apart from the zero flag
+		parity := g.GetFreeReg("TEMP")
+		if cc == "fe" {
+			g.out.WriteString("sete " + StorageLocs8[dest] + "\n")
+			g.out.WriteString("setnp " + StorageLocs8[parity] + "\n")
+			g.out.WriteString("andb " + StorageLocs8[parity] + ", " + StorageLocs8[dest] + "\n")
+		} else {
+			g.out.WriteString("setne " + StorageLocs8[dest] + "\n")
+			g.out.WriteString("setp " + StorageLocs8[parity] + "\n")
+			g.out.WriteString("orb " + StorageLocs8[parity] + ", " + StorageLocs8[dest] + "\n")
+		}
+		delete(g.VirtualRegisters, parity)
+	default:
+		g.out.WriteString("set" + cc + " " + StorageLocs8[dest] + "\n")
+	}
+	g.out.WriteString("movzbq " + StorageLocs8[dest] + ", " + StorageLocs[dest] + "\n")
+	return dest
+}
 
+// GenerateLogical materialises the result of && or || as 0 or 1, only working out the right operand if it is needed.
+func (g *X64Generator) GenerateLogical(node *ast.InfixExpression) StorageLoc {
+	tracer.Trace("GenerateLogical")
+	defer tracer.Untrace("GenerateLogical")
+	dest := g.GetFreeReg("TEMP")
+	falseLabel := g.NewLabel()
+	endLabel := g.NewLabel()
+	g.GenerateConditionalJump(node.Token, node, falseLabel, false)
+	g.out.WriteString("movq $1, " + StorageLocs[dest] + "\n")
+	g.out.WriteString("jmp " + endLabel + "\n")
+	g.PlaceLabel(falseLabel)
+	g.out.WriteString("movq $0, " + StorageLocs[dest] + "\n")
+	g.PlaceLabel(endLabel)
+	return dest
 }
 
-// TODO: add support in parser and generator for while loops and then for loops
+var invertedConditions = map[string]string{
+	"e": "ne", "ne": "e",
+	"l": "ge", "ge": "l",
+	"g": "le", "le": "g",
+	"b": "ae", "ae": "b",
+	"a": "be", "be": "a",
+	// float equality, which has to check for NaN with the parity flag as well
+	"fe": "fne", "fne": "fe",
+}
+
+// GenerateConditionalJump jumps to label if the condition c of the statement at tok is when.
+// Comparisons jump on the flags directly, && and || skip their right operand if the left one decides the result,
+// and anything else is true if it is not zero.
+func (g *X64Generator) GenerateConditionalJump(tok lex.LexedTok, c ast.Expression, label string, when bool) {
+	tracer.Trace("GenerateConditionalJump")
+	defer tracer.Untrace("GenerateConditionalJump")
+	switch c := c.(type) {
+	case *ast.InfixExpression:
+		switch {
+		case codegen.IsComparison(c.Operator):
+			cc := g.GenerateCompare(c)
+			if !when {
+				cc = invertedConditions[cc]
+			}
+			switch cc {
+			case "fe":
+				skip := g.NewLabel()
+				g.out.WriteString("jp " + skip + "\n")
+				g.out.WriteString("je " + label + "\n")
+				g.PlaceLabel(skip)
+			case "fne":
+				g.out.WriteString("jp " + label + "\n")
+				g.out.WriteString("jne " + label + "\n")
+			default:
+				g.out.WriteString("j" + cc + " " + label + "\n")
+			}
+			return
+		case c.Operator == "&&" && when, c.Operator == "||" && !when:
+			// both operands have to be when, so the first one that isn't skips the jump
+			skip := g.NewLabel()
+			g.GenerateConditionalJump(tok, c.Left, skip, !when)
+			g.GenerateConditionalJump(tok, c.Right, label, when)
+			g.PlaceLabel(skip)
+			return
+		case c.Operator == "&&", c.Operator == "||":
+			// either operand being when is enough to jump
+			g.GenerateConditionalJump(tok, c.Left, label, when)
+			g.GenerateConditionalJump(tok, c.Right, label, when)
+			return
+		}
+	case *ast.PrefixExpression:
+		if c.Operator == "!" {
+			g.GenerateConditionalJump(tok, c.Right, label, !when)
+			return
+		}
+	}
+	sloc := g.GenerateExpression(c)
+	if sloc == NULLSTORAGE {
+		g.e(tok, "condition has no value: "+c.String())
+	}
+	g.out.WriteString("cmpq $0, " + StorageLocs[sloc] + "\n")
+	if when {
+		g.out.WriteString("jne " + label + "\n")
+	} else {
+		g.out.WriteString("je " + label + "\n")
+	}
+}
@@ -0,0 +1,121 @@
+package x86_64_as
+
+// runtime implements codegen.Builtins. It is emitted once, alongside main.
+// Strings made by concat are allocated with malloc and never freed. Everything is printed with printf, so output
+// from print shares a buffer with calls to printf from the program and comes out in order.
+const runtime = `.text
+.type len, @function
+len:
+movq -8(%rdi), %rax
+ret
+.type compare, @function
+compare:
+pushq %r12
+pushq %r13
+subq $8, %rsp
+movq -8(%rdi), %r12
+movq -8(%rsi), %r13
+movq %r12, %rdx
+cmpq %r13, %rdx
+cmovgq %r13, %rdx
+call memcmp@PLT
+movslq %eax, %rax
+testq %rax, %rax
+jne .Lcompare_done
+movq %r12, %rax
+subq %r13, %rax
+.Lcompare_done:
+addq $8, %rsp
+popq %r13
+popq %r12
+ret
+.type concat, @function
+concat:
+pushq %r12
+pushq %r13
+pushq %r14
+movq %rdi, %r12
+movq %rsi, %r13
+movq -8(%rdi), %r14
+addq -8(%rsi), %r14
+leaq 9(%r14), %rdi
+call malloc@PLT
+movq %r14, (%rax)
+leaq 8(%rax), %r14
+movq %r14, %rdi
+movq %r12, %rsi
+movq -8(%r12), %rdx
+call memcpy@PLT
+movq %r14, %rdi
+addq -8(%r12), %rdi
+movq %r13, %rsi
+movq -8(%r13), %rdx
+incq %rdx
+call memcpy@PLT
+movq %r14, %rax
+popq %r14
+popq %r13
+popq %r12
+ret
+.type print_string, @function
+print_string:
+leaq .Lfmt_string(%rip), %rax
+leaq .Lfmt_stringln(%rip), %rdx
+jmp .Lprint
+.type print_int, @function
+print_int:
+leaq .Lfmt_int(%rip), %rax
+leaq .Lfmt_intln(%rip), %rdx
+.Lprint:
+testb %sil, %sil
+cmovneq %rdx, %rax
+movq %rdi, %rsi
+movq %rax, %rdi
+pushq %rbp
+movq %rsp, %rbp
+andq $-16, %rsp
+xorl %eax, %eax
+call printf@PLT
+leave
+ret
+.type print_float, @function
+print_float:
+leaq .Lfmt_float(%rip), %rax
+leaq .Lfmt_floatln(%rip), %rdx
+testb %dil, %dil
+cmovneq %rdx, %rax
+movq %rax, %rdi
+pushq %rbp
+movq %rsp, %rbp
+andq $-16, %rsp
+movl $1, %eax
+call printf@PLT
+leave
+ret
+.type print_bool, @function
+print_bool:
+leaq .Ltrue(%rip), %rax
+leaq .Lfalse(%rip), %rdx
+testb %dil, %dil
+cmoveq %rdx, %rax
+movq %rax, %rdi
+jmp print_string
+.section .rodata
+.Lfmt_string:
+.asciz "%s"
+.Lfmt_stringln:
+.asciz "%s\n"
+.Lfmt_int:
+.asciz "%lld"
+.Lfmt_intln:
+.asciz "%lld\n"
+.Lfmt_float:
+.asciz "%g"
+.Lfmt_floatln:
+.asciz "%g\n"
+.Ltrue:
+.asciz "true"
+.Lfalse:
+.asciz "false"
+.text
+`
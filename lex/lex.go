@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Position struct {
@@ -23,6 +26,16 @@ type Lexer struct {
 	pos    Position
 	reader *bufio.Reader
 	rdr    *os.File
+	// Defines holds what other files have @defined, for @ifdef and @ifndef. It may be nil.
+	Defines map[string]string
+	lexed   bool
+	result  lexResult
+}
+
+type lexResult struct {
+	tokens   []LexedTok
+	imported []string
+	defined  map[string]string
 }
 
 func NewLexer(reader *os.File) *Lexer {
@@ -37,16 +50,54 @@ func (l *Lexer) GetRdrFname() string {
 	return l.rdr.Name()
 }
 
+// Lex lexes the whole file, returning its tokens along with what it imports and @defines.
+// The result is cached, as conditional compilation depends on what was defined when the file was first lexed.
 func (l *Lexer) Lex() ([]LexedTok, []string, map[string]string) {
+	if l.lexed {
+		return l.result.tokens, l.result.imported, l.result.defined
+	}
 	var tokens []LexedTok
 	var imported []string
 	defined := make(map[string]string)
+	// one entry per open @ifdef/@ifndef
+	type condition struct {
+		pos    Position
+		active bool // whether the region is being compiled
+	}
+	var conditions []condition
+	active := func() bool {
+		return len(conditions) == 0 || conditions[len(conditions)-1].active
+	}
 	l.rdr.Seek(0, io.SeekStart)
 	l.reader = bufio.NewReader(l.rdr)
 	l.pos.col = 0
 	l.pos.line = 1
 	for {
 		pos, tok, val := l.LexChar()
+		switch tok {
+		case IFDEF, IFNDEF:
+			_, _, name := l.LexChar()
+			isDefined := l.isDefined(name, defined)
+			// a region nested in an excluded one is excluded whatever its condition
+			conditions = append(conditions, condition{pos: pos, active: active() && isDefined == (tok == IFDEF)})
+			continue
+		case ENDIF:
+			if len(conditions) == 0 {
+				l.e(pos, "@endif without matching @ifdef or @ifndef")
+			}
+			conditions = conditions[:len(conditions)-1]
+			continue
+		case EOF:
+			if len(conditions) != 0 {
+				l.e(conditions[len(conditions)-1].pos, "unterminated @ifdef or @ifndef")
+			}
+		}
+		if !active() {
+			if tok == NEWLINE {
+				tokens = append(tokens, NewLexedTok(pos, tok, val))
+			}
+			continue
+		}
 		if tok == IMPORT {
 			pos, tok, val = l.LexChar()
 			imported = append(imported, val)
@@ -55,20 +106,44 @@ func (l *Lexer) Lex() ([]LexedTok, []string, map[string]string) {
 			// @define HELLO 3
 			// p, t, v of HELLO
 			_, _, name := l.LexChar()
-			// TODO: adapt so that stuff like "@define FILE" works as in C - FILE is set to 1
 			// p, t, v, of 3
-			_, _, sub := l.LexChar()
-			defined[name] = sub
-
+			p, t, sub := l.LexChar()
+			if t == NEWLINE || t == EOF {
+				// "@define FILE" works as in C - FILE is set to 1
+				defined[name] = "1"
+				pos, tok, val = p, t, sub
+			} else {
+				defined[name] = sub
+				continue
+			}
+		} else if tok == UNDEF {
+			_, _, name := l.LexChar()
+			delete(defined, name)
+			delete(l.Defines, name)
 			continue
 		}
 		tokens = append(tokens, NewLexedTok(pos, tok, val))
 		if tok == EOF {
+			l.lexed = true
+			l.result = lexResult{tokens: tokens, imported: imported, defined: defined}
 			return tokens, imported, defined
 		}
 	}
 }
 
+func (l *Lexer) isDefined(name string, defined map[string]string) bool {
+	if _, ok := defined[name]; ok {
+		return true
+	}
+	_, ok := l.Defines[name]
+	return ok
+}
+
+func (l *Lexer) e(pos Position, err string) {
+	fmt.Printf("%v - %s\n", pos, err)
+	os.Exit(1)
+}
+
 func (l *Lexer) LexChar() (Position, Token, string) {
 	for {
 		r, _, err := l.reader.ReadRune()
@@ -86,42 +161,92 @@ func (l *Lexer) LexChar() (Position, Token, string) {
 			l.resetPosition()
 			return l.pos, NEWLINE, string(r)
 		case '+':
-			return l.pos, ADD, string(r)
+			startPos := l.pos
+			switch {
+			case l.lexFollowedBy('+'):
+				return startPos, INC, "++"
+			case l.lexFollowedBy('='):
+				return startPos, ADDASSIGN, "+="
+			}
+			return startPos, ADD, string(r)
 		case '*':
-			return l.pos, MUL, string(r)
+			startPos := l.pos
+			if l.lexFollowedBy('=') {
+				return startPos, MULASSIGN, "*="
+			}
+			return startPos, MUL, string(r)
 		case '-':
-			return l.pos, SUB, string(r)
+			startPos := l.pos
+			switch {
+			case l.lexFollowedBy('-'):
+				return startPos, DEC, "--"
+			case l.lexFollowedBy('='):
+				return startPos, SUBASSIGN, "-="
+			}
+			return startPos, SUB, string(r)
 		case '|':
+			startPos := l.pos
 			t, s := l.lexPipe(r)
-			return l.pos, t, s
+			return startPos, t, s
 		case '&':
+			startPos := l.pos
 			t, s := l.lexAmpersand(r)
-			return l.pos, t, s
+			return startPos, t, s
 		case '^':
 			return l.pos, BWXOR, string(r)
 		case '~':
 			return l.pos, BWNOT, string(r)
 		case '/':
+			startPos := l.pos
 			sym, val := l.lexSlash(string(r))
-			return l.pos, sym, val
+			if sym == NEWLINE {
+				return l.pos, sym, val
+			}
+			return startPos, sym, val
 		case '%':
 			return l.pos, MOD, string(r)
 		case '=':
+			startPos := l.pos
 			t, s := l.lexEquals(r)
-			return l.pos, t, s
+			return startPos, t, s
 		case '!':
+			startPos := l.pos
 			t, s := l.lexBang(r)
-			return l.pos, t, s
+			return startPos, t, s
 		case '<':
-			return l.pos, LT, string(r)
+			startPos := l.pos
+			switch {
+			case l.lexFollowedBy('<'):
+				return startPos, LSHIFT, "<<"
+			case l.lexFollowedBy('='):
+				return startPos, LTEQUALS, "<="
+			}
+			return startPos, LT, string(r)
 		case '>':
-			return l.pos, GT, string(r)
+			startPos := l.pos
+			switch {
+			case l.lexFollowedBy('>'):
+				return startPos, RSHIFT, ">>"
+			case l.lexFollowedBy('='):
+				return startPos, GTEQUALS, ">="
+			}
+			return startPos, GT, string(r)
 		case '(':
 			return l.pos, LPAREN, string(r)
 		case ')':
 			return l.pos, RPAREN, string(r)
 		case ',':
 			return l.pos, COMMA, string(r)
+		case ':':
+			return l.pos, COLON, string(r)
+		case '.':
+			startPos := l.pos
+			if next, err := l.reader.Peek(2); err == nil && string(next) == ".." {
+				l.reader.Discard(2)
+				l.pos.col += 2
+				return startPos, ELLIPSIS, "..."
+			}
+			return l.pos, DOT, string(r)
 		case '[':
 			return l.pos, LSQRBRAC, string(r)
 		case ']':
@@ -134,11 +259,22 @@ func (l *Lexer) LexChar() (Position, Token, string) {
 			startPos := l.pos
 			l.backup()
 			lit := l.lexCompilerInstruction()
-			if lit == "@import" {
+			switch lit {
+			case "@import":
 				return startPos, IMPORT, lit
-			} else if lit == "@define" {
+			case "@define":
 				return startPos, DEFINE, lit
-			} else {
+			case "@ifdef":
+				return startPos, IFDEF, lit
+			case "@ifndef":
+				return startPos, IFNDEF, lit
+			case "@endif":
+				return startPos, ENDIF, lit
+			case "@undef":
+				return startPos, UNDEF, lit
+			case "@extern":
+				return startPos, EXTERN, lit
+			default:
 				return startPos, ILLEGAL, lit
 			}
 		case '"':
@@ -151,8 +287,8 @@ func (l *Lexer) LexChar() (Position, Token, string) {
 			} else if unicode.IsDigit(r) {
 				startPos := l.pos
 				l.backup()
-				lit := l.lexInt()
-				return startPos, INTLITERAL, lit
+				tok, lit := l.lexNumber()
+				return startPos, tok, lit
 			} else if unicode.IsLetter(r) {
 				startPos := l.pos
 				l.backup()
@@ -216,6 +352,32 @@ func (l *Lexer) lexInt() string {
 	}
 }
 
+// lexNumber lexes an integer, or a float if it has a fractional part or an exponent e.g. 1.0, 3e-2.
+func (l *Lexer) lexNumber() (Token, string) {
+	lit := l.lexInt()
+	tok := INTLITERAL
+	// only take the dot if a digit follows, so that the dot can still be used after an integer
+	if next, err := l.reader.Peek(2); err == nil && next[0] == '.' && unicode.IsDigit(rune(next[1])) {
+		l.reader.ReadRune()
+		l.pos.col++
+		lit = lit + "." + l.lexInt()
+		tok = FLOATLITERAL
+	}
+	if next, err := l.reader.Peek(1); err == nil && (next[0] == 'e' || next[0] == 'E') {
+		exp := 1
+		if more, err := l.reader.Peek(2); err == nil && (more[1] == '+' || more[1] == '-') {
+			exp = 2
+		}
+		if digits, err := l.reader.Peek(exp + 1); err == nil && unicode.IsDigit(rune(digits[exp])) {
+			l.reader.Discard(exp)
+			l.pos.col += exp
+			lit = lit + string(digits[:exp]) + l.lexInt()
+			tok = FLOATLITERAL
+		}
+	}
+	return tok, lit
+}
+
 func (l *Lexer) lexIdent() string {
 	var lit string
 	for {
@@ -236,25 +398,82 @@ func (l *Lexer) lexIdent() string {
 	}
 }
 
+// lexString lexes a string literal up to the closing quote, replacing escape sequences with what they stand for:
+// \n \t \r \0 \\ \" \xNN for a byte and \u{N...} for a unicode code point.
 func (l *Lexer) lexString() string {
-	var lit string
+	var lit []byte
 	for {
 		r, _, err := l.reader.ReadRune()
 		if err != nil {
-			if err == io.EOF {
-				return lit
-			}
+			l.e(l.pos, "unterminated string literal")
 		}
 
 		l.pos.col++
-		if r == '"' {
-			return lit
-		} else {
-			lit = lit + string(r)
+		switch r {
+		case '"':
+			return string(lit)
+		case '\\':
+			lit = append(lit, l.lexEscape()...)
+		case '\n':
+			lit = append(lit, '\n')
+			l.pos.line++
+			l.pos.col = 0
+		default:
+			lit = utf8.AppendRune(lit, r)
 		}
 	}
 }
 
+// lexEscape lexes the escape sequence after a backslash in a string and returns the bytes it stands for.
+func (l *Lexer) lexEscape() []byte {
+	pos := l.pos
+	r, _, err := l.reader.ReadRune()
+	if err != nil {
+		l.e(pos, "unterminated string literal")
+	}
+	l.pos.col++
+	switch r {
+	case 'n':
+		return []byte{'\n'}
+	case 't':
+		return []byte{'\t'}
+	case 'r':
+		return []byte{'\r'}
+	case '0':
+		return []byte{0}
+	case '\\', '"', '\'':
+		return []byte{byte(r)}
+	case 'x':
+		digits := make([]byte, 2)
+		if _, err := io.ReadFull(l.reader, digits); err != nil {
+			l.e(pos, "\\x must be followed by two hex digits")
+		}
+		l.pos.col += 2
+		b, err := strconv.ParseUint(string(digits), 16, 8)
+		if err != nil {
+			l.e(pos, "\\x must be followed by two hex digits, got "+string(digits))
+		}
+		return []byte{byte(b)}
+	case 'u':
+		if open, _, _ := l.reader.ReadRune(); open != '{' {
+			l.e(pos, "\\u must be followed by a code point in braces, like \\u{1F600}")
+		}
+		l.pos.col++
+		digits, err := l.reader.ReadString('}')
+		if err != nil {
+			l.e(pos, "unterminated \\u{...} escape")
+		}
+		l.pos.col += len(digits)
+		cp, err := strconv.ParseUint(strings.TrimSuffix(digits, "}"), 16, 32)
+		if err != nil || !utf8.ValidRune(rune(cp)) {
+			l.e(pos, "invalid code point in \\u{"+digits)
+		}
+		return utf8.AppendRune(nil, rune(cp))
+	}
+	l.e(pos, "unknown escape sequence \\"+string(r))
+	return nil
+}
+
 func (l *Lexer) lexCompilerInstruction() string {
 	var lit string
 	for {
@@ -266,7 +485,11 @@ func (l *Lexer) lexCompilerInstruction() string {
 		}
 
 		l.pos.col++
-		if r != ' ' {
+		if r == '\n' {
+			// leave the newline to be lexed, directives like @endif have nothing after them
+			l.backup()
+			return lit
+		} else if !unicode.IsSpace(r) {
 			lit = lit + string(r)
 		} else {
 			return lit
@@ -274,52 +497,50 @@ func (l *Lexer) lexCompilerInstruction() string {
 	}
 }
 
+// lexFollowedBy consumes the next rune only if it is next, so that two character operators can be told apart from one character ones.
+func (l *Lexer) lexFollowedBy(next rune) bool {
+	r, _, err := l.reader.ReadRune()
+	if err != nil {
+		return false
+	}
+	if r != next {
+		l.reader.UnreadRune()
+		return false
+	}
+	l.pos.col++
+	return true
+}
+
 func (l *Lexer) lexEquals(r rune) (Token, string) {
 	s := string(r)
-	r, _, _ = l.reader.ReadRune()
-	s = s + string(r)
-	switch r {
-	case '=':
-		return EQUALS, s
-	default:
-		return ASSIGN, s
+	if l.lexFollowedBy('=') {
+		return EQUALS, s + "="
 	}
+	return ASSIGN, s
 }
 
 func (l *Lexer) lexAmpersand(r rune) (Token, string) {
 	s := string(r)
-	r, _, _ = l.reader.ReadRune()
-	switch r {
-	case '&':
-		s = s + string(r)
-		return AND, s
-	default:
-		return BWAND, s
+	if l.lexFollowedBy('&') {
+		return AND, s + "&"
 	}
+	return BWAND, s
 }
 
 func (l *Lexer) lexPipe(r rune) (Token, string) {
 	s := string(r)
-	r, _, _ = l.reader.ReadRune()
-	switch r {
-	case '|':
-		s = s + string(r)
-		return OR, s
-	default:
-		return BWOR, s
+	if l.lexFollowedBy('|') {
+		return OR, s + "|"
 	}
+	return BWOR, s
 }
 
 func (l *Lexer) lexBang(r rune) (Token, string) {
 	s := string(r)
-	r, _, _ = l.reader.ReadRune()
-	s = s + string(r)
-	switch r {
-	case '=':
-		return NOTEQUALS, s
-	default:
-		return NOT, s
+	if l.lexFollowedBy('=') {
+		return NOTEQUALS, s + "="
 	}
+	return NOT, s
 }
 
 func (l *Lexer) lexSlash(r string) (Token, string) {
@@ -335,8 +556,11 @@ func (l *Lexer) lexSlash(r string) (Token, string) {
 			}
 			l.resetPosition()
 			return NEWLINE, "\n"
+		} else if r == '=' {
+			l.pos.col++
+			return DIVASSIGN, lit + "="
 		} else {
-			l.backup()
+			l.reader.UnreadRune()
 			return DIV, lit
 		}
 	}
@@ -19,6 +19,7 @@ const (
 	CONTINUE
 	AS
 	TYPEDEF
+	STRUCT
 	// end of language keywords
 	// compiler directives
 	IMPORT
@@ -31,6 +32,12 @@ const (
 	// end of compiler directives
 	TYPE
 	ASSIGN
+	ADDASSIGN
+	SUBASSIGN
+	MULASSIGN
+	DIVASSIGN
+	INC
+	DEC
 	ADD
 	MUL
 	SUB
@@ -43,6 +50,7 @@ const (
 	BLOCKSTART
 	BLOCKEND
 	INTLITERAL
+	FLOATLITERAL
 	STRINGLITERAL
 	NEWLINE
 	AND
@@ -54,11 +62,18 @@ const (
 	BWXOR
 	GT
 	LT
+	GTEQUALS
+	LTEQUALS
+	LSHIFT
+	RSHIFT
 	TRUE
 	FALSE
 	NOTEQUALS
 	EQUALS
 	COMMA
+	COLON
+	DOT
+	ELLIPSIS
 )
 
 var tokens = []string{
@@ -74,6 +89,7 @@ var tokens = []string{
 	CONTINUE:      "CONTINUE",
 	AS:            "AS",
 	TYPEDEF:       "TYPEDEF",
+	STRUCT:        "STRUCT",
 	IMPORT:        "IMPORT",
 	DEFINE:        "DEFINE",
 	EXTERN:        "EXTERN",
@@ -83,6 +99,12 @@ var tokens = []string{
 	UNDEF:         "UNDEF",
 	TYPE:          "TYPE",
 	ASSIGN:        "ASSIGN",
+	ADDASSIGN:     "ADDASSIGN",
+	SUBASSIGN:     "SUBASSIGN",
+	MULASSIGN:     "MULASSIGN",
+	DIVASSIGN:     "DIVASSIGN",
+	INC:           "INC",
+	DEC:           "DEC",
 	ADD:           "ADD",
 	MUL:           "MUL",
 	SUB:           "SUB",
@@ -95,6 +117,7 @@ var tokens = []string{
 	BLOCKSTART:    "BLOCKSTART",
 	BLOCKEND:      "BLOCKEND",
 	INTLITERAL:    "INTLITERAL",
+	FLOATLITERAL:  "FLOATLITERAL",
 	STRINGLITERAL: "STRINGLITERAL",
 	NEWLINE:       "NEWLINE",
 	AND:           "AND",
@@ -106,11 +129,18 @@ var tokens = []string{
 	BWXOR:         "BWXOR",
 	GT:            "GT",
 	LT:            "LT",
+	GTEQUALS:      "GTEQUALS",
+	LTEQUALS:      "LTEQUALS",
+	LSHIFT:        "LSHIFT",
+	RSHIFT:        "RSHIFT",
 	TRUE:          "TRUE",
 	FALSE:         "FALSE",
 	NOTEQUALS:     "NOTEQUALS",
 	EQUALS:        "EQUALS",
 	COMMA:         "COMMA",
+	COLON:         "COLON",
+	DOT:           "DOT",
+	ELLIPSIS:      "ELLIPSIS",
 }
 var keywords = []string{
 	"if",
@@ -122,6 +152,7 @@ var keywords = []string{
 	"continue",
 	"as",
 	"typedef",
+	"struct",
 }
 
 var kwmap = map[string]Token{
@@ -134,6 +165,7 @@ var kwmap = map[string]Token{
 	"continue": CONTINUE,
 	"as":       AS,
 	"typedef":  TYPEDEF,
+	"struct":   STRUCT,
 }
 
 var types = []string{
@@ -169,6 +201,7 @@ func (lt *LexedTok) String() string {
 
 var datatypes = map[Token]string{
 	INTLITERAL:    "int",
+	FLOATLITERAL:  "double",
 	STRINGLITERAL: "string",
 	TRUE:          "bool",
 	FALSE:         "bool",
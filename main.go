@@ -1,11 +1,5 @@
 package main
 
-/*
-TODO:
-bring new label improvements to x86_64 for nested loops
-
-*/
-
 import (
 	"flag"
 	"fmt"
@@ -14,6 +8,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/westsi/dormouse/ast"
 	"github.com/westsi/dormouse/builtin"
 	"github.com/westsi/dormouse/codegen"
 	"github.com/westsi/dormouse/codegen/aarch64_clang"
@@ -24,6 +19,10 @@ import (
 )
 
 var globalDefines = make(map[string]string)
+var globalTypedefs = make(map[string]string)
+var globalSignatures = make(map[string]codegen.Signature)
+var globalStructs = make(map[string]codegen.Struct)
+var globalVars = make(map[string]string)
 var condcnt int = 0
 
 func main() {
@@ -32,11 +31,13 @@ func main() {
 	isDebug := flag.Bool("d", false, "debug")
 	OutFname := flag.String("o", "", "output file name")
 	targetArch := flag.String("a", "x86_64", "target architecture")
+	boundsChecks := flag.Bool("b", false, "check array indices at runtime")
 	flag.Parse()
 	opts.Verbose = *isVerbose
 	opts.Debug = *isDebug
 	opts.OutFname = *OutFname
 	opts.TargetArch = *targetArch
+	opts.BoundsChecks = *boundsChecks
 	opts.Fname = flag.Arg(0)
 	if opts.OutFname == "" {
 		opts.OutFname = strings.Split(strings.Split(opts.Fname, ".")[0], "/")[len(strings.Split(opts.Fname, "/"))-1] + ".s"
@@ -55,13 +56,59 @@ func run(opts Options) {
 	lexers, _ := ResolveImports([]string{}, opts.BaseDir, opts.Fname)
 	var asmNames []string
 
+	// typedefs need to be known before any file is parsed, as a file can use aliases from files it imports
 	for _, lexer := range lexers {
+		if lexer == nil {
+			continue
+		}
+		tokens, _, _ := lexer.Lex()
+		for k, v := range parse.CollectTypedefs(tokens) {
+			if val, ok := globalTypedefs[k]; ok && val != v {
+				fmt.Printf("WARNING: %s has already been typedef'd as %s. It is being overwritten.", k, val)
+			}
+			globalTypedefs[k] = v
+		}
+	}
+
+	// every file is parsed before any is generated so that calls into other files can be checked
+	programs := make([]*ast.Program, len(lexers))
+	for i, lexer := range lexers {
+		if lexer == nil {
+			continue
+		}
+		programs[i] = Parse(lexer)
+		sigs, err := codegen.CollectSignatures(programs[i])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for k, v := range sigs {
+			if _, ok := globalSignatures[k]; ok {
+				fmt.Printf("WARNING: %s has already been declared. It is being overwritten.", k)
+			}
+			globalSignatures[k] = v
+		}
+	}
+	structs, err := codegen.CollectStructs(programs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	globalStructs = structs
+	vars, err := codegen.CollectGlobals(programs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	globalVars = vars
+
+	for i, lexer := range lexers {
 		if lexer == nil {
 			continue
 		}
 		asmNames = append(asmNames, strings.Split((strings.Split(lexer.GetRdrFname(), "/")[len(strings.Split(lexer.GetRdrFname(), "/"))-1]), ".")[0]+".s")
 		fmt.Println("Compiling", lexer.GetRdrFname())
-		Compile(&opts, lexer)
+		Compile(&opts, lexer, programs[i])
 	}
 	CompileAll(opts, asmNames)
 }
@@ -80,24 +127,31 @@ func CompileAll(opts Options, fnames []string) {
 		}
 	}
 
-	out, err := exec.Command("gcc", "-o"+"out/"+opts.TargetArch+"/"+strings.Split(opts.Fname, ".")[0], "out/"+opts.TargetArch+"/asm/___concat.s").Output()
+	out, err := exec.Command("gcc", "-o"+"out/"+opts.TargetArch+"/"+strings.Split(opts.Fname, ".")[0], "out/"+opts.TargetArch+"/asm/___concat.s").CombinedOutput()
 	if err != nil {
-		print(string(out))
+		fmt.Print(string(out))
+		fmt.Println("assembling and linking failed:", err)
+		os.Exit(1)
 	}
 }
 
-func Compile(opts *Options, lexer *lex.Lexer) {
+func Parse(lexer *lex.Lexer) *ast.Program {
 	tokens, _, _ := lexer.Lex()
-	fname := strings.Split((strings.Split(lexer.GetRdrFname(), "/")[len(strings.Split(lexer.GetRdrFname(), "/"))-1]), ".")[0]
 	p := parse.New(tokens)
-	ast := p.Parse()
+	p.RegisterTypedefs(globalTypedefs)
+	program := p.Parse()
 	if len(p.Errors()) > 0 {
-		fmt.Println("Errors:")
+		fmt.Println("Errors in", lexer.GetRdrFname()+":")
 		for _, err := range p.Errors() {
 			fmt.Println(err)
 		}
 		os.Exit(1)
 	}
+	return program
+}
+
+func Compile(opts *Options, lexer *lex.Lexer, ast *ast.Program) {
+	fname := strings.Split((strings.Split(lexer.GetRdrFname(), "/")[len(strings.Split(lexer.GetRdrFname(), "/"))-1]), ".")[0]
 
 	// ssag := ssa.New(fname+".dssa", ast, globalDefines)
 	// ssag.Generate()
@@ -107,9 +161,9 @@ func Compile(opts *Options, lexer *lex.Lexer) {
 	var cg codegen.CodeGenerator
 	switch opts.TargetArch {
 	case "x86_64":
-		cg = x86_64_as.New(fname+".s", ast, globalDefines, condcnt)
+		cg = x86_64_as.New(fname+".s", ast, globalDefines, globalSignatures, globalStructs, globalVars, condcnt, opts.BoundsChecks)
 	case "aarch64":
-		cg = aarch64_clang.New(fname+".s", ast, globalDefines, condcnt)
+		cg = aarch64_clang.New(fname+".s", ast, globalDefines, globalSignatures, globalStructs, globalVars, condcnt, opts.BoundsChecks)
 	}
 	condcnt = cg.Generate()
 	cg.Write()
@@ -130,6 +184,7 @@ func ResolveImports(prevImps []string, baseDir, imp string) ([]*lex.Lexer, []str
 	}
 
 	lexer := lex.NewLexer(reader)
+	lexer.Defines = globalDefines
 	lexers = append(lexers, lexer)
 	_, imported, defined := lexer.Lex()
 	for k, v := range defined {
@@ -143,7 +198,7 @@ func ResolveImports(prevImps []string, baseDir, imp string) ([]*lex.Lexer, []str
 	prevImps = append(prevImps, imp)
 	for _, imp := range imported {
 		if strings.HasPrefix(imp, "dor.") {
-			l, pi, d := builtin.HandleStdlib(prevImps, strings.Replace(imp, "dor.", "", 1))
+			l, pi, d := builtin.HandleStdlib(prevImps, strings.Replace(imp, "dor.", "", 1), globalDefines)
 			for k, v := range d {
 				if val, ok := globalDefines[k]; ok {
 					// define already exists, warn of overwriting but allow it
@@ -169,4 +224,6 @@ type Options struct {
 	BaseDir    string
 	OutFname   string
 	TargetArch string
+	// abort when an array is indexed out of bounds
+	BoundsChecks bool
 }
@@ -19,6 +19,9 @@ type Parser struct {
 
 	prefixParseFuncs map[lex.Token]prefixParseFunc
 	infixParseFuncs  map[lex.Token]infixParseFunc
+
+	// typedef aliases mapped to the built in types they resolve to
+	typedefs map[string]string
 }
 
 type (
@@ -39,21 +42,26 @@ func (p *Parser) noPrefixParseFuncError(t lex.Token) {
 
 func New(tokens []lex.LexedTok) *Parser {
 	pr := NewParseReader(tokens)
-	p := &Parser{pr: pr, errors: []string{}}
+	p := &Parser{pr: pr, errors: []string{}, typedefs: map[string]string{}}
 	p.nextTok()
 	p.nextTok()
 
 	p.prefixParseFuncs = make(map[lex.Token]prefixParseFunc)
 	p.registerPrefix(lex.IDENT, p.parseIdentifier)
 	p.registerPrefix(lex.INTLITERAL, p.parseIntegerLiteral)
+	p.registerPrefix(lex.FLOATLITERAL, p.parseFloatLiteral)
 	p.registerPrefix(lex.STRINGLITERAL, p.parseStringLiteral)
+	p.registerPrefix(lex.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(lex.NOT, p.parsePrefixExpression)
 	p.registerPrefix(lex.SUB, p.parsePrefixExpression)
 	p.registerPrefix(lex.BWNOT, p.parsePrefixExpression)
+	p.registerPrefix(lex.BWAND, p.parsePrefixExpression)
+	p.registerPrefix(lex.MUL, p.parsePrefixExpression)
 	p.registerPrefix(lex.TRUE, p.parseBoolean)
 	p.registerPrefix(lex.FALSE, p.parseBoolean)
 	p.registerPrefix(lex.IF, p.parseIfExpression)
 	p.registerPrefix(lex.WHILE, p.parseWhileExpression)
+	p.registerPrefix(lex.FOR, p.parseForExpression)
 	// p.registerPrefix(lex.FUNC, p.parseFunctionDefinition)
 	// p.registerPrefix(lex.EFUNC, p.parseEntrypointFunctionDefinition)
 	p.infixParseFuncs = make(map[lex.Token]infixParseFunc)
@@ -61,19 +69,111 @@ func New(tokens []lex.LexedTok) *Parser {
 	p.registerInfix(lex.SUB, p.parseInfixExpression)
 	p.registerInfix(lex.MUL, p.parseInfixExpression)
 	p.registerInfix(lex.DIV, p.parseInfixExpression)
+	p.registerInfix(lex.MOD, p.parseInfixExpression)
 	p.registerInfix(lex.EQUALS, p.parseInfixExpression)
 	p.registerInfix(lex.NOTEQUALS, p.parseInfixExpression)
 	p.registerInfix(lex.LT, p.parseInfixExpression)
 	p.registerInfix(lex.GT, p.parseInfixExpression)
+	p.registerInfix(lex.LTEQUALS, p.parseInfixExpression)
+	p.registerInfix(lex.GTEQUALS, p.parseInfixExpression)
+	p.registerInfix(lex.LSHIFT, p.parseInfixExpression)
+	p.registerInfix(lex.RSHIFT, p.parseInfixExpression)
 	p.registerInfix(lex.LPAREN, p.parseCallExpression)
+	p.registerInfix(lex.LSQRBRAC, p.parseIndexExpression)
+	p.registerInfix(lex.DOT, p.parseFieldExpression)
 	p.registerInfix(lex.AND, p.parseInfixExpression)
 	p.registerInfix(lex.OR, p.parseInfixExpression)
 	p.registerInfix(lex.BWAND, p.parseInfixExpression)
 	p.registerInfix(lex.BWOR, p.parseInfixExpression)
 	p.registerInfix(lex.BWXOR, p.parseInfixExpression)
+	p.registerInfix(lex.AS, p.parseCastExpression)
 	return p
 }
 
+// RegisterTypedefs makes aliases declared in other files usable in this one.
+func (p *Parser) RegisterTypedefs(typedefs map[string]string) {
+	for k, v := range typedefs {
+		// an alias can refer to an alias from a different file, so follow the chain to the built in type
+		for i := 0; i < len(typedefs); i++ {
+			next, ok := typedefs[v]
+			if !ok {
+				break
+			}
+			v = next
+		}
+		p.typedefs[k] = v
+	}
+}
+
+// CollectTypedefs finds every typedef in tokens so that they can be shared between files before parsing.
+// Aliases of aliases are resolved to the built in type.
+// Struct names are types too, so they are collected as aliases of themselves.
+func CollectTypedefs(tokens []lex.LexedTok) map[string]string {
+	typedefs := make(map[string]string)
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].Tok == lex.STRUCT && tokens[i+1].Tok == lex.IDENT {
+			typedefs[tokens[i+1].Val] = tokens[i+1].Val
+			continue
+		}
+		if tokens[i].Tok != lex.TYPEDEF {
+			continue
+		}
+		underlying, name := tokens[i+1], tokens[i+2]
+		if (underlying.Tok != lex.TYPE && underlying.Tok != lex.IDENT) || name.Tok != lex.IDENT {
+			continue
+		}
+		if resolved, ok := typedefs[underlying.Val]; ok {
+			typedefs[name.Val] = resolved
+		} else {
+			typedefs[name.Val] = underlying.Val
+		}
+	}
+	return typedefs
+}
+
+// isType reports whether tok names a type, either built in or declared with typedef.
+func (p *Parser) isType(tok lex.LexedTok) bool {
+	if tok.Tok == lex.TYPE {
+		return true
+	}
+	_, ok := p.typedefs[tok.Val]
+	return tok.Tok == lex.IDENT && ok
+}
+
+// newType builds the type named by tok, resolving typedef aliases to the type they stand for.
+func (p *Parser) newType(tok lex.LexedTok) *ast.Type {
+	if underlying, ok := p.typedefs[tok.Val]; ok && tok.Tok == lex.IDENT {
+		return &ast.Type{Token: tok, Value: underlying}
+	}
+	return &ast.Type{Token: tok, Value: tok.Val}
+}
+
+// parseType parses the type at the current token along with any pointer and array suffixes,
+// so int[10] is a fixed size array, int[] is an array passed by pointer and int* is a pointer.
+func (p *Parser) parseType() *ast.Type {
+	defer tracer.Untrace(tracer.Trace("parseType"))
+	t := p.newType(p.curTok)
+	for {
+		switch {
+		case p.peekTokenIs(lex.MUL):
+			p.nextTok()
+			t.Value += "*"
+		case p.peekTokenIs(lex.LSQRBRAC):
+			p.nextTok()
+			length := ""
+			if p.expectPeek(lex.INTLITERAL) {
+				length = p.curTok.Val
+			}
+			if !p.expectPeek(lex.RSQRBRAC) {
+				p.e(lex.RSQRBRAC, p.peekTok.Tok)
+			}
+			t.Value = fmt.Sprintf("%s[%s]", t.Value, length)
+		default:
+			return t
+		}
+	}
+}
+
 func (p *Parser) nextTok() {
 	p.curTok = p.peekTok
 	pt := p.pr.Read()
@@ -125,16 +225,34 @@ func (p *Parser) parseStatement() ast.Statement {
 	switch p.curTok.Tok {
 	case lex.RETURN:
 		return p.parseReturnStatement()
+	case lex.TYPEDEF:
+		return p.parseTypedefStatement()
+	case lex.STRUCT:
+		return p.parseStructDefinition()
+	case lex.EXTERN:
+		return p.parseExternDeclaration()
+	case lex.BREAK:
+		return p.parseBreakStatement()
+	case lex.CONTINUE:
+		return p.parseContinueStatement()
 	case lex.NEWLINE:
 		return nil
 	case lex.TYPE:
 		return p.parseTypeBeginStatement()
 	case lex.IDENT:
+		if p.isType(p.curTok) && (p.peekTokenIs(lex.IDENT) || p.peekTokenIs(lex.LSQRBRAC) || p.peekTokenIs(lex.MUL)) {
+			return p.parseTypeBeginStatement()
+		}
 		if p.peekTokenIs(lex.LPAREN) {
 			// fmt.Println("Is function call")
 			return p.parseExpressionStatement()
 		}
+		if p.peekTokenIs(lex.LSQRBRAC) || p.peekTokenIs(lex.DOT) {
+			return p.parseAssignment(p.curTok)
+		}
 		return p.parseVarReassignment(p.curTok)
+	case lex.MUL:
+		return p.parseAssignment(p.curTok)
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -182,6 +300,17 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	defer tracer.Untrace(tracer.Trace("parseFloatLiteral"))
+	lit := &ast.FloatLiteral{Token: p.curTok}
+	val, err := strconv.ParseFloat(p.curTok.Val, 64)
+	if err != nil {
+		p.errors = append(p.errors, fmt.Sprintf("could not parse %q as float: error: %v", p.curTok.Val, err.Error()))
+	}
+	lit.Value = val
+	return lit
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	defer tracer.Untrace(tracer.Trace("parseStringLiteral"))
 	lit := &ast.StringLiteral{Token: p.curTok}
@@ -197,6 +326,25 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	}
 	p.nextTok()
 	exp.Right = p.parseExpression(PREFIX)
+	// negative literals are folded so that they can be used anywhere a literal can
+	if exp.Operator == "-" {
+		switch right := exp.Right.(type) {
+		case *ast.IntegerLiteral:
+			return &ast.IntegerLiteral{Token: exp.Token, Value: -right.Value}
+		case *ast.FloatLiteral:
+			return &ast.FloatLiteral{Token: exp.Token, Value: -right.Value}
+		}
+	}
+	return exp
+}
+
+func (p *Parser) parseGroupedExpression() ast.Expression {
+	defer tracer.Untrace(tracer.Trace("parseGroupedExpression"))
+	p.nextTok()
+	exp := p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.RPAREN) {
+		return nil
+	}
 	return exp
 }
 
@@ -213,6 +361,31 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseCastExpression(left ast.Expression) ast.Expression {
+	defer tracer.Untrace(tracer.Trace("parseCastExpression"))
+	exp := &ast.CastExpression{Token: p.curTok, Left: left}
+	if !p.isType(p.peekTok) {
+		p.e(lex.TYPE, p.peekTok.Tok)
+		return nil
+	}
+	p.nextTok()
+	exp.Type = p.newType(p.curTok)
+	// x as int * 2 multiplies, so stars are only part of the type when no operand follows them
+	stars := 0
+	for p.pr.Peek(stars).Tok == lex.MUL {
+		stars++
+	}
+	if p.peekTokenIs(lex.MUL) {
+		if _, operand := p.prefixParseFuncs[p.pr.Peek(stars).Tok]; !operand {
+			for i := 0; i <= stars; i++ {
+				p.nextTok()
+				exp.Type.Value += "*"
+			}
+		}
+	}
+	return exp
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	defer tracer.Untrace(tracer.Trace("parseBoolean"))
 	return &ast.Boolean{Token: p.curTok, Value: p.curTokenIs(lex.TRUE)}
@@ -222,32 +395,51 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	defer tracer.Untrace(tracer.Trace("parseIfExpression"))
 	exp := &ast.IfExpression{Token: p.curTok}
 
-	if !p.expectPeek(lex.LPAREN) {
-		return nil
-	}
-	p.nextTok()
-	exp.Condition = p.parseExpression(LOWEST)
-	if !p.expectPeek(lex.RPAREN) {
+	exp.Condition, exp.Consequence = p.parseConditionalBlock()
+	if exp.Consequence == nil {
 		return nil
 	}
 
-	if !p.expectPeek(lex.BLOCKSTART) {
-		return nil
-	}
-	exp.Consequence = p.parseBlockStatement()
-
-	if p.peekTokenIs(lex.ELSE) {
+	for p.peekTokenIs(lex.ELSE) {
 		p.nextTok()
 
+		if p.expectPeek(lex.IF) {
+			elseIf := &ast.ElseIf{Token: p.curTok}
+			elseIf.Condition, elseIf.Consequence = p.parseConditionalBlock()
+			if elseIf.Consequence == nil {
+				return nil
+			}
+			exp.ElseIfs = append(exp.ElseIfs, elseIf)
+			continue
+		}
 		if !p.expectPeek(lex.BLOCKSTART) {
 			return nil
 		}
 		exp.Alternative = p.parseBlockStatement()
+		break
 	}
 
 	return exp
 }
 
+// parseConditionalBlock parses the (condition) { block } after an if, returning a nil block if either is missing.
+func (p *Parser) parseConditionalBlock() (ast.Expression, *ast.BlockStatement) {
+	defer tracer.Untrace(tracer.Trace("parseConditionalBlock"))
+	if !p.expectPeek(lex.LPAREN) {
+		return nil, nil
+	}
+	p.nextTok()
+	condition := p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.RPAREN) {
+		return nil, nil
+	}
+
+	if !p.expectPeek(lex.BLOCKSTART) {
+		return nil, nil
+	}
+	return condition, p.parseBlockStatement()
+}
+
 func (p *Parser) parseWhileExpression() ast.Expression {
 	defer tracer.Untrace(tracer.Trace("parseWhileExpression"))
 	w := &ast.WhileExpression{Token: p.curTok}
@@ -263,9 +455,74 @@ func (p *Parser) parseWhileExpression() ast.Expression {
 		return nil
 	}
 	w.Body = p.parseBlockStatement()
+	w.Alternative = p.parseLoopAlternative()
 	return w
 }
 
+func (p *Parser) parseForExpression() ast.Expression {
+	defer tracer.Untrace(tracer.Trace("parseForExpression"))
+	// for (int i: 0, y, 1) - for TYPE VAR: start, end, increment
+	f := &ast.ForExpression{Token: p.curTok}
+	if !p.expectPeek(lex.LPAREN) {
+		return nil
+	}
+	if !p.isType(p.peekTok) {
+		p.e(lex.TYPE, p.peekTok.Tok)
+		return nil
+	}
+	p.nextTok()
+	typeTok := p.curTok
+	if !p.expectPeek(lex.IDENT) {
+		p.e(lex.IDENT, p.peekTok.Tok)
+		return nil
+	}
+	f.Init = &ast.VarStatement{Token: typeTok, Type: p.newType(typeTok)}
+	f.Init.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	if !p.expectPeek(lex.COLON) {
+		p.e(lex.COLON, p.peekTok.Tok)
+		return nil
+	}
+	p.nextTok()
+	f.Init.Value = &ast.ExpressionStatement{Token: p.curTok, Expression: p.parseExpression(LOWEST)}
+	if !p.expectPeek(lex.COMMA) {
+		p.e(lex.COMMA, p.peekTok.Tok)
+		return nil
+	}
+	p.nextTok()
+	f.Limit = p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.COMMA) {
+		p.e(lex.COMMA, p.peekTok.Tok)
+		return nil
+	}
+	p.nextTok()
+	f.Step = p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.RPAREN) {
+		p.e(lex.RPAREN, p.peekTok.Tok)
+		return nil
+	}
+	if !p.expectPeek(lex.BLOCKSTART) {
+		p.e(lex.BLOCKSTART, p.peekTok.Tok)
+		return nil
+	}
+	f.Body = p.parseBlockStatement()
+	f.Alternative = p.parseLoopAlternative()
+	return f
+}
+
+// parseLoopAlternative parses the optional else block of a loop, which runs if the loop was not broken out of.
+func (p *Parser) parseLoopAlternative() *ast.BlockStatement {
+	defer tracer.Untrace(tracer.Trace("parseLoopAlternative"))
+	if !p.peekTokenIs(lex.ELSE) {
+		return nil
+	}
+	p.nextTok()
+	if !p.expectPeek(lex.BLOCKSTART) {
+		p.e(lex.BLOCKSTART, p.peekTok.Tok)
+		return nil
+	}
+	return p.parseBlockStatement()
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	defer tracer.Untrace(tracer.Trace("parseBlockStatement"))
 	block := &ast.BlockStatement{Token: p.curTok}
@@ -299,51 +556,89 @@ func (p *Parser) parseFunctionParameters() []*ast.Parameter {
 		return parameters
 	}
 	p.nextTok()
+	parameters = append(parameters, p.parseParameter())
+
+	for p.peekTokenIs(lex.COMMA) {
+		p.nextTok()
+		p.nextTok()
+		parameters = append(parameters, p.parseParameter())
+	}
+	if !p.expectPeek(lex.RPAREN) {
+		return nil
+	}
+	return parameters
+}
+
+func (p *Parser) parseParameter() *ast.Parameter {
+	defer tracer.Untrace(tracer.Trace("parseParameter"))
 	param := &ast.Parameter{}
-	if !p.curTokenIs(lex.TYPE) {
+	if !p.isType(p.curTok) {
 		p.e(lex.TYPE, p.curTok.Tok)
 	}
-	param.Type = &ast.Type{Token: p.curTok, Value: p.curTok.Val}
+	param.Type = p.parseType()
 	p.nextTok()
 	if !p.curTokenIs(lex.IDENT) {
 		p.e(lex.IDENT, p.curTok.Tok)
 	}
 	param.Token = p.curTok
 	param.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
-	parameters = append(parameters, param)
+	return param
+}
 
-	for p.peekTokenIs(lex.COMMA) {
-		p.nextTok()
+func (p *Parser) parseExternDeclaration() *ast.ExternDeclaration {
+	defer tracer.Untrace(tracer.Trace("parseExternDeclaration"))
+	// @extern int printf(string format, ...)
+	decl := &ast.ExternDeclaration{Token: p.curTok}
+	if !p.isType(p.peekTok) {
+		p.e(lex.TYPE, p.peekTok.Tok)
+		return nil
+	}
+	p.nextTok()
+	decl.ReturnType = p.parseType()
+	if !p.expectPeek(lex.IDENT) {
+		p.e(lex.IDENT, p.peekTok.Tok)
+		return nil
+	}
+	decl.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	if !p.expectPeek(lex.LPAREN) {
+		p.e(lex.LPAREN, p.peekTok.Tok)
+		return nil
+	}
+	decl.Parameters = []*ast.Parameter{}
+	for !p.peekTokenIs(lex.RPAREN) {
 		p.nextTok()
-		param := &ast.Parameter{}
-		if !p.curTokenIs(lex.TYPE) {
-			p.e(lex.TYPE, p.curTok.Tok)
+		if p.curTokenIs(lex.ELLIPSIS) {
+			// variadic arguments have to come last
+			decl.Variadic = true
+			break
 		}
-		param.Type = &ast.Type{Token: p.curTok, Value: p.curTok.Val}
-		p.nextTok()
-		if !p.curTokenIs(lex.IDENT) {
-			p.e(lex.IDENT, p.curTok.Tok)
+		decl.Parameters = append(decl.Parameters, p.parseParameter())
+		if !p.peekTokenIs(lex.COMMA) {
+			break
 		}
-		param.Token = p.curTok
-		param.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
-		parameters = append(parameters, param)
+		p.nextTok()
 	}
 	if !p.expectPeek(lex.RPAREN) {
+		p.e(lex.RPAREN, p.peekTok.Tok)
 		return nil
 	}
-	return parameters
+	if p.peekTokenIs(lex.NEWLINE) {
+		p.nextTok()
+	}
+	return decl
 }
 
 func (p *Parser) parseTypeBeginStatement() ast.Statement {
 	defer tracer.Untrace(tracer.Trace("parseTypeBeginStatement"))
 	// need to check if it is a variable or a function
 	startTok := p.curTok
+	t := p.parseType()
 	p.nextTok()
 	var stmt ast.Statement
 	if p.peekTokenIs(lex.LPAREN) {
-		stmt = p.parseFunctionDefinition(startTok)
+		stmt = p.parseFunctionDefinition(startTok, t)
 	} else {
-		stmt = p.parseVarStatement(startTok)
+		stmt = p.parseVarStatement(startTok, t)
 	}
 	if p.peekTokenIs(lex.NEWLINE) {
 		p.nextTok()
@@ -351,15 +646,22 @@ func (p *Parser) parseTypeBeginStatement() ast.Statement {
 	return stmt
 }
 
-func (p *Parser) parseVarStatement(startTok lex.LexedTok) *ast.VarStatement {
+func (p *Parser) parseVarStatement(startTok lex.LexedTok, t *ast.Type) *ast.VarStatement {
 	defer tracer.Untrace(tracer.Trace("parseVarStatement"))
 	stmt := &ast.VarStatement{Token: startTok}
-	stmt.Type = &ast.Type{Token: startTok, Value: startTok.Val}
+	stmt.Type = t
 
 	if !p.curTokenIs(lex.IDENT) {
 		p.e(lex.IDENT, p.curTok.Tok)
 	}
 	stmt.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	// variables without a value start zeroed
+	if !p.peekTokenIs(lex.ASSIGN) {
+		if p.peekTokenIs(lex.NEWLINE) {
+			p.nextTok()
+		}
+		return stmt
+	}
 
 	if !p.expectPeek(lex.ASSIGN) {
 		p.e(lex.ASSIGN, p.curTok.Tok)
@@ -376,20 +678,79 @@ func (p *Parser) parseVarReassignment(startTok lex.LexedTok) ast.Statement {
 	defer tracer.Untrace(tracer.Trace("parseVarReassignment"))
 	stmt := &ast.VarReassignmentStatement{Token: startTok}
 	stmt.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
-	if !p.expectPeek(lex.ASSIGN) {
+	stmt.Value = p.parseAssignedValue(stmt.Name)
+	return stmt
+}
+
+func (p *Parser) parseAssignment(startTok lex.LexedTok) ast.Statement {
+	defer tracer.Untrace(tracer.Trace("parseAssignment"))
+	stmt := &ast.AssignmentStatement{Token: startTok}
+	// only indexing and field access bind tighter than PREFIX, so this stops before the assignment operator
+	stmt.Target = p.parseExpression(PREFIX)
+	switch target := stmt.Target.(type) {
+	case *ast.IndexExpression, *ast.FieldExpression:
+	case *ast.PrefixExpression:
+		if target.Operator != "*" {
+			p.errors = append(p.errors, fmt.Sprintf("cannot assign to %s", stmt.Target.String()))
+			fmt.Printf("Errored at %s:%s\n", p.curTok.Pos.String(), p.curTok.Tok.String())
+			return nil
+		}
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("cannot assign to %s", stmt.Target.String()))
+		fmt.Printf("Errored at %s:%s\n", p.curTok.Pos.String(), p.curTok.Tok.String())
+		return nil
+	}
+	stmt.Value = p.parseAssignedValue(stmt.Target)
+	return stmt
+}
+
+// parseAssignedValue parses everything after the target of an assignment.
+// Compound assignments are turned into plain ones, so x += 2 becomes x = x + 2 and x++ becomes x = x + 1
+func (p *Parser) parseAssignedValue(target ast.Expression) ast.Expression {
+	defer tracer.Untrace(tracer.Trace("parseAssignedValue"))
+	var value ast.Expression
+	if op, ok := stepOperators[p.peekTok.Tok]; ok {
+		p.nextTok()
+		one := &ast.IntegerLiteral{Token: p.curTok, Value: 1}
+		value = &ast.InfixExpression{Token: p.curTok, Left: target, Operator: op, Right: one}
+		if p.peekTokenIs(lex.NEWLINE) {
+			p.nextTok()
+		}
+		return value
+	}
+	op, compound := compoundOperators[p.peekTok.Tok]
+	if compound {
+		p.nextTok()
+	} else if !p.expectPeek(lex.ASSIGN) {
 		p.e(lex.ASSIGN, p.curTok.Tok)
 	}
+	opTok := p.curTok
 	p.nextTok()
-	stmt.Value = p.parseExpressionStatement().Expression
+	value = p.parseExpressionStatement().Expression
+	if compound {
+		value = &ast.InfixExpression{Token: opTok, Left: target, Operator: op, Right: value}
+	}
 	if p.peekTokenIs(lex.NEWLINE) {
 		p.nextTok()
 	}
-	return stmt
+	return value
+}
+
+var compoundOperators = map[lex.Token]string{
+	lex.ADDASSIGN: "+",
+	lex.SUBASSIGN: "-",
+	lex.MULASSIGN: "*",
+	lex.DIVASSIGN: "/",
 }
 
-func (p *Parser) parseFunctionDefinition(startTok lex.LexedTok) *ast.FunctionDefinition {
+var stepOperators = map[lex.Token]string{
+	lex.INC: "+",
+	lex.DEC: "-",
+}
+
+func (p *Parser) parseFunctionDefinition(startTok lex.LexedTok, returnType *ast.Type) *ast.FunctionDefinition {
 	defer tracer.Untrace(tracer.Trace("parseFunctionDefinition"))
-	fd := &ast.FunctionDefinition{Token: startTok, ReturnType: &ast.Type{Token: startTok, Value: startTok.Val}}
+	fd := &ast.FunctionDefinition{Token: startTok, ReturnType: returnType}
 	fd.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
 	if !p.expectPeek(lex.LPAREN) {
 		return nil
@@ -415,6 +776,78 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseTypedefStatement() *ast.TypedefStatement {
+	defer tracer.Untrace(tracer.Trace("parseTypedefStatement"))
+	// typedef int Meters
+	stmt := &ast.TypedefStatement{Token: p.curTok}
+	if !p.isType(p.peekTok) {
+		p.e(lex.TYPE, p.peekTok.Tok)
+		return nil
+	}
+	p.nextTok()
+	stmt.Type = p.newType(p.curTok)
+	if !p.expectPeek(lex.IDENT) {
+		p.e(lex.IDENT, p.peekTok.Tok)
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	p.typedefs[stmt.Name.Value] = stmt.Type.Value
+	if p.peekTokenIs(lex.NEWLINE) {
+		p.nextTok()
+	}
+	return stmt
+}
+
+func (p *Parser) parseStructDefinition() *ast.StructDefinition {
+	defer tracer.Untrace(tracer.Trace("parseStructDefinition"))
+	// struct Point { int x  int y }
+	stmt := &ast.StructDefinition{Token: p.curTok}
+	if !p.expectPeek(lex.IDENT) {
+		p.e(lex.IDENT, p.peekTok.Tok)
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	p.typedefs[stmt.Name.Value] = stmt.Name.Value
+	if !p.expectPeek(lex.BLOCKSTART) {
+		p.e(lex.BLOCKSTART, p.peekTok.Tok)
+		return nil
+	}
+	stmt.Fields = []*ast.Parameter{}
+	p.nextTok()
+	for !p.curTokenIs(lex.BLOCKEND) {
+		if p.curTokenIs(lex.EOF) {
+			p.e(lex.BLOCKEND, p.curTok.Tok)
+			return nil
+		}
+		if !p.curTokenIs(lex.NEWLINE) && !p.curTokenIs(lex.COMMA) {
+			stmt.Fields = append(stmt.Fields, p.parseParameter())
+		}
+		p.nextTok()
+	}
+	if p.peekTokenIs(lex.NEWLINE) {
+		p.nextTok()
+	}
+	return stmt
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	defer tracer.Untrace(tracer.Trace("parseBreakStatement"))
+	stmt := &ast.BreakStatement{Token: p.curTok}
+	if p.peekTokenIs(lex.NEWLINE) {
+		p.nextTok()
+	}
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	defer tracer.Untrace(tracer.Trace("parseContinueStatement"))
+	stmt := &ast.ContinueStatement{Token: p.curTok}
+	if p.peekTokenIs(lex.NEWLINE) {
+		p.nextTok()
+	}
+	return stmt
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	defer tracer.Untrace(tracer.Trace("parseIdentifier"))
 	return &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
@@ -427,6 +860,28 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	defer tracer.Untrace(tracer.Trace("parseIndexExpression"))
+	exp := &ast.IndexExpression{Token: p.curTok, Left: left}
+	p.nextTok()
+	exp.Index = p.parseExpression(LOWEST)
+	if !p.expectPeek(lex.RSQRBRAC) {
+		p.e(lex.RSQRBRAC, p.peekTok.Tok)
+	}
+	return exp
+}
+
+func (p *Parser) parseFieldExpression(left ast.Expression) ast.Expression {
+	defer tracer.Untrace(tracer.Trace("parseFieldExpression"))
+	exp := &ast.FieldExpression{Token: p.curTok, Left: left}
+	if !p.expectPeek(lex.IDENT) {
+		p.e(lex.IDENT, p.peekTok.Tok)
+		return nil
+	}
+	exp.Field = &ast.Identifier{Token: p.curTok, Value: p.curTok.Val}
+	return exp
+}
+
 func (p *Parser) parseCallArguments() []ast.Expression {
 	defer tracer.Untrace(tracer.Trace("parseCallArguments"))
 	args := []ast.Expression{}
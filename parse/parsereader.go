@@ -33,6 +33,14 @@ func (p *ParseReader) Read() lex.LexedTok {
 	return tok
 }
 
+// Peek returns the token n places after the next one Read would return, without consuming anything.
+func (p *ParseReader) Peek(n int) lex.LexedTok {
+	if p.eof || p.idx+n >= len(p.tokens) {
+		return lex.LexedTok{Pos: lex.Position{}, Tok: lex.EOF, Val: ""}
+	}
+	return p.tokens[p.idx+n]
+}
+
 func (p *ParseReader) PrintRem() {
 	for _, tok := range p.tokens {
 		fmt.Print(tok)
@@ -5,12 +5,14 @@ import "github.com/westsi/dormouse/lex"
 const (
 	_ = iota
 	LOWEST
+	LOGICAL
 	EQUALS
 	LESSGREATER
-	LOGICAL
 	BITWISE
+	SHIFT
 	SUM
 	PRODUCT
+	CAST
 	PREFIX
 	CALL
 )
@@ -20,6 +22,8 @@ var precedences = map[lex.Token]int{
 	lex.NOTEQUALS: EQUALS,
 	lex.LT:        LESSGREATER,
 	lex.GT:        LESSGREATER,
+	lex.LTEQUALS:  LESSGREATER,
+	lex.GTEQUALS:  LESSGREATER,
 	lex.AND:       LOGICAL,
 	lex.OR:        LOGICAL,
 	lex.NOT:       LOGICAL,
@@ -27,11 +31,17 @@ var precedences = map[lex.Token]int{
 	lex.BWAND:     BITWISE,
 	lex.BWNOT:     BITWISE,
 	lex.BWOR:      BITWISE,
+	lex.LSHIFT:    SHIFT,
+	lex.RSHIFT:    SHIFT,
 	lex.ADD:       SUM,
 	lex.SUB:       SUM,
 	lex.MUL:       PRODUCT,
 	lex.DIV:       PRODUCT,
+	lex.MOD:       PRODUCT,
+	lex.AS:        CAST,
 	lex.LPAREN:    CALL,
+	lex.LSQRBRAC:  CALL,
+	lex.DOT:       CALL,
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -20,6 +20,10 @@ func (s *Stack[T]) Pop() T {
 	return element
 }
 
+func (s *Stack[T]) Peek() T {
+	return s.Elements[len(s.Elements)-1]
+}
+
 func (s *Stack[T]) Size() int {
 	return len(s.Elements)
 }